@@ -0,0 +1,207 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"langfuse-analyzer-backend/apperr"
+	"langfuse-analyzer-backend/heuristics"
+	"langfuse-analyzer-backend/langfuse"
+)
+
+// Analyzer вызывает AIClient и проверяет его ответ. Если ответ не прошел
+// проверку, модель повторно просят исправить его, передавая найденные
+// проблемы, но не более maxAttempts обращений к модели в сумме.
+type Analyzer struct {
+	client      AIClient
+	prompts     *Prompts
+	maxAttempts int
+}
+
+// NewAnalyzer создает Analyzer поверх клиента. Если prompts равен nil,
+// используются встроенные шаблоны.
+func NewAnalyzer(client AIClient, prompts *Prompts, maxAttempts int) *Analyzer {
+	if prompts == nil {
+		prompts = DefaultPrompts()
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = 3
+	}
+	return &Analyzer{
+		client:      client,
+		prompts:     prompts,
+		maxAttempts: maxAttempts,
+	}
+}
+
+// PromptVersion возвращает версию шаблонов промптов, которыми пользуется Analyzer
+func (a *Analyzer) PromptVersion() string {
+	return a.prompts.Version()
+}
+
+// Analyze анализирует трейс или сессию и возвращает проверенный отчет
+func (a *Analyzer) Analyze(ctx context.Context, req *AnalysisRequest) (*AnalysisResult, error) {
+	return a.analyze(ctx, req, nil, nil)
+}
+
+// AnalyzeStream работает как Analyze, но передает фрагменты ответа в onToken.
+// onRepair (может быть nil) вызывается перед каждой попыткой исправления.
+func (a *Analyzer) AnalyzeStream(ctx context.Context, req *AnalysisRequest, onToken func(string), onRepair func(problems []string)) (*AnalysisResult, error) {
+	return a.analyze(ctx, req, onToken, onRepair)
+}
+
+func (a *Analyzer) analyze(ctx context.Context, req *AnalysisRequest, onToken func(string), onRepair func([]string)) (*AnalysisResult, error) {
+	attemptReq := *req
+	attemptReq.Repair = nil
+	attemptReq.Prompts = a.prompts
+
+	var lastErr error
+	for attempt := 1; attempt <= a.maxAttempts; attempt++ {
+		var raw string
+		var err error
+		if onToken != nil {
+			raw, err = a.client.AnalyzeTraceStream(ctx, &attemptReq, onToken)
+		} else {
+			raw, err = a.client.AnalyzeTrace(ctx, &attemptReq)
+		}
+		if err != nil {
+			return nil, providerError(err)
+		}
+
+		result, err := ParseAnalysisResult(raw, req)
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("✅ Ответ модели исправлен с попытки %d", attempt)
+			}
+			result.fillIDs(req)
+			return result, nil
+		}
+
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			return nil, err
+		}
+		lastErr = err
+		log.Printf("⚠️  Попытка %d/%d: %v", attempt, a.maxAttempts, err)
+
+		if attempt < a.maxAttempts {
+			if onRepair != nil {
+				onRepair(validationErr.Problems)
+			}
+			attemptReq.Repair = &Repair{
+				PreviousResponse: raw,
+				Problems:         validationErr.Problems,
+			}
+		}
+	}
+
+	return nil, lastErr
+}
+
+// AnalyzeChunks анализирует большой трейс по частям (map) и объединяет
+// отчеты частей в один отчет отдельным запросом к модели (reduce).
+// req.Trace - полный трейс, chunks - его части (см. compact.Split), не более
+// concurrency частей анализируются одновременно. Каждой части передаются
+// только факты о ее наблюдениях; этапу reduce - все факты. onChunk (может
+// быть nil) вызывается после каждой проанализированной части.
+func (a *Analyzer) AnalyzeChunks(ctx context.Context, req *AnalysisRequest, chunks []*langfuse.Trace, concurrency int, onChunk func(done, total int)) (*AnalysisResult, error) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	partials := make([]*AnalysisResult, len(chunks))
+	errs := make(chan error, len(chunks))
+	sem := make(chan struct{}, concurrency)
+	var mu sync.Mutex
+	done := 0
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func(i int, chunk *langfuse.Trace) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				return
+			}
+
+			chunkReq := &AnalysisRequest{
+				Trace:    chunk,
+				Facts:    factsFor(req.Facts, chunk),
+				Language: req.Language,
+				Chunk:    &Chunk{Index: i + 1, Total: len(chunks)},
+			}
+			result, err := a.analyze(ctx, chunkReq, nil, nil)
+			if err != nil {
+				errs <- fmt.Errorf("часть %d из %d: %w", i+1, len(chunks), err)
+				cancel()
+				return
+			}
+			partials[i] = result
+			log.Printf("🧩 Часть %d/%d: %s / %s", i+1, len(chunks),
+				result.AnalysisSummary.OverallStatus, result.DetailedAnalysis.AnomalyType)
+
+			mu.Lock()
+			done++
+			if onChunk != nil {
+				onChunk(done, len(chunks))
+			}
+			mu.Unlock()
+		}(i, chunk)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	reduceReq := *req
+	reduceReq.Partials = partials
+	return a.analyze(ctx, &reduceReq, nil, nil)
+}
+
+// factsFor оставляет факты, относящиеся к наблюдениям части трейса
+func factsFor(facts []heuristics.Finding, chunk *langfuse.Trace) []heuristics.Finding {
+	ids := make(map[string]bool, len(chunk.Observations))
+	for _, o := range chunk.Observations {
+		ids[o.ID] = true
+	}
+	var out []heuristics.Finding
+	for _, f := range facts {
+		for _, id := range f.ObservationIDs {
+			if ids[id] {
+				out = append(out, f)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// fillIDs подставляет ID трейса или сессии, если модель их не указала
+func (r *AnalysisResult) fillIDs(req *AnalysisRequest) {
+	if req.Trace != nil && r.AnalysisSummary.TraceID == "" {
+		r.AnalysisSummary.TraceID = req.Trace.ID
+	}
+	if req.Session != nil && r.AnalysisSummary.SessionID == "" {
+		r.AnalysisSummary.SessionID = req.Session.ID
+	}
+}
+
+// providerError относит к таксономии API ошибки клиента, которые не
+// описаны через AIError: обрыв потока, неразобранный ответ провайдера
+func providerError(err error) error {
+	var conv apperr.Converter
+	if errors.As(err, &conv) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return apperr.Wrap(apperr.CodeUpstreamError, "AI провайдер вернул некорректный ответ", err)
+}
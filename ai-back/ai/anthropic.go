@@ -0,0 +1,365 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"langfuse-analyzer-backend/apperr"
+)
+
+// Способы заставить Claude вернуть JSON
+const (
+	// AnthropicJSONPrefill - ответ ассистента начинается с "{", модель продолжает объект
+	AnthropicJSONPrefill = "prefill"
+	// AnthropicJSONTool - модель обязана вызвать инструмент, аргументы которого и есть отчет
+	AnthropicJSONTool = "tool"
+)
+
+const (
+	anthropicVersion  = "2023-06-01"
+	anthropicToolName = "submit_analysis"
+)
+
+// AnthropicClient - клиент для Anthropic Messages API
+type AnthropicClient struct {
+	apiKey    string
+	baseURL   string
+	model     string
+	maxTokens int
+	jsonMode  string
+	client    *http.Client
+}
+
+// NewAnthropicClient создает нового клиента для Anthropic
+func NewAnthropicClient(apiKey, baseURL, model string, maxTokens int, jsonMode string, timeout time.Duration) *AnthropicClient {
+	if baseURL == "" {
+		baseURL = "https://api.anthropic.com"
+	}
+	if model == "" {
+		model = "claude-sonnet-4-5"
+	}
+	if maxTokens <= 0 {
+		maxTokens = 1000
+	}
+	if jsonMode != AnthropicJSONTool {
+		jsonMode = AnthropicJSONPrefill
+	}
+	if timeout <= 0 {
+		timeout = 120 * time.Second
+	}
+
+	return &AnthropicClient{
+		apiKey:    apiKey,
+		baseURL:   strings.TrimRight(baseURL, "/"),
+		model:     model,
+		maxTokens: maxTokens,
+		jsonMode:  jsonMode,
+		client: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+// AnthropicRequest - тело запроса POST /v1/messages
+type AnthropicRequest struct {
+	Model      string               `json:"model"`
+	System     string               `json:"system,omitempty"`
+	Messages   []AnthropicMessage   `json:"messages"`
+	MaxTokens  int                  `json:"max_tokens"`
+	Stream     bool                 `json:"stream,omitempty"`
+	Tools      []AnthropicTool      `json:"tools,omitempty"`
+	ToolChoice *AnthropicToolChoice `json:"tool_choice,omitempty"`
+}
+
+// AnthropicMessage - сообщение диалога
+type AnthropicMessage struct {
+	Role    string `json:"role"` // "user" или "assistant"
+	Content string `json:"content"`
+}
+
+// AnthropicTool - описание инструмента
+type AnthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+// AnthropicToolChoice - какой инструмент модель обязана вызвать
+type AnthropicToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+// AnthropicResponse - ответ POST /v1/messages без потока
+type AnthropicResponse struct {
+	Content    []AnthropicContentBlock `json:"content"`
+	StopReason string                  `json:"stop_reason"`
+	Usage      anthropicUsage          `json:"usage"`
+}
+
+// anthropicUsage - расход токенов запроса
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// AnthropicContentBlock - блок ответа: текст или вызов инструмента
+type AnthropicContentBlock struct {
+	Type  string          `json:"type"`
+	Text  string          `json:"text,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+}
+
+// anthropicStreamEvent - событие потока; используются только нужные поля
+type anthropicStreamEvent struct {
+	Type  string `json:"type"`
+	Delta struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+	} `json:"delta"`
+	// Токены запроса приходят в message_start, токены ответа - в message_delta
+	Message struct {
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"`
+	Usage anthropicUsage  `json:"usage"`
+	Error *anthropicError `json:"error"`
+}
+
+// anthropicError - тело ошибки Anthropic API
+type anthropicError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// analysisToolSchema - схема аргументов инструмента. Проверяется только
+// общая форма: значения полей проверяет ParseAnalysisResult.
+var analysisToolSchema = json.RawMessage(`{
+  "type": "object",
+  "properties": {
+    "analysisSummary": {"type": "object"},
+    "detailedAnalysis": {"type": "object"},
+    "traceReferences": {"type": "array", "items": {"type": "object"}},
+    "findings": {"type": "array", "items": {"type": "object"}}
+  },
+  "required": ["analysisSummary", "detailedAnalysis", "findings"]
+}`)
+
+// messagesRequest формирует запрос Messages API: системные сообщения
+// переносятся в поле system, JSON обеспечивается prefill или инструментом
+func (c *AnthropicClient) messagesRequest(analysisReq *AnalysisRequest, stream bool) (*AnthropicRequest, error) {
+	messages, err := buildMessages(analysisReq)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &AnthropicRequest{
+		Model:     c.model,
+		MaxTokens: c.maxTokens,
+		Stream:    stream,
+	}
+	var system []string
+	for _, m := range messages {
+		if m.Role == "system" {
+			system = append(system, m.Content)
+			continue
+		}
+		req.Messages = append(req.Messages, AnthropicMessage{Role: m.Role, Content: m.Content})
+	}
+	req.System = strings.Join(system, "\n\n")
+
+	if c.jsonMode == AnthropicJSONTool {
+		req.Tools = []AnthropicTool{{
+			Name:        anthropicToolName,
+			Description: "Submit the analysis report in the required JSON format.",
+			InputSchema: analysisToolSchema,
+		}}
+		req.ToolChoice = &AnthropicToolChoice{Type: "tool", Name: anthropicToolName}
+	} else {
+		req.Messages = append(req.Messages, AnthropicMessage{Role: "assistant", Content: "{"})
+	}
+	return req, nil
+}
+
+// Close закрывает простаивающие соединения клиента
+func (c *AnthropicClient) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+// AnalyzeTrace - анализ трейса через Anthropic
+func (c *AnthropicClient) AnalyzeTrace(ctx context.Context, analysisReq *AnalysisRequest) (string, error) {
+	req, err := c.messagesRequest(analysisReq, false)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var msg AnthropicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		return "", fmt.Errorf("ошибка декодирования ответа от Anthropic: %w", err)
+	}
+	recordUsage(ctx, msg.Usage.InputTokens, msg.Usage.OutputTokens)
+
+	var content strings.Builder
+	for _, block := range msg.Content {
+		switch block.Type {
+		case "tool_use":
+			return string(block.Input), nil
+		case "text":
+			content.WriteString(block.Text)
+		}
+	}
+	if content.Len() == 0 {
+		return "", fmt.Errorf("нет ответа от AI")
+	}
+	if c.jsonMode == AnthropicJSONPrefill {
+		return "{" + content.String(), nil
+	}
+	return content.String(), nil
+}
+
+// AnalyzeTraceStream - потоковый анализ трейса через Anthropic. Поток
+// приходит как Server-Sent Events; фрагменты текста - в content_block_delta.
+func (c *AnthropicClient) AnalyzeTraceStream(ctx context.Context, analysisReq *AnalysisRequest, onToken func(string)) (string, error) {
+	req, err := c.messagesRequest(analysisReq, true)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var content strings.Builder
+	if c.jsonMode == AnthropicJSONPrefill {
+		content.WriteString("{")
+		onToken("{")
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		var event anthropicStreamEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &event); err != nil {
+			return "", fmt.Errorf("ошибка декодирования потока от Anthropic: %w", err)
+		}
+
+		switch event.Type {
+		case "message_start":
+			recordUsage(ctx, event.Message.Usage.InputTokens, event.Message.Usage.OutputTokens)
+		case "message_delta":
+			recordUsage(ctx, event.Usage.InputTokens, event.Usage.OutputTokens)
+		case "content_block_delta":
+			delta := event.Delta.Text
+			if event.Delta.Type == "input_json_delta" {
+				delta = event.Delta.PartialJSON
+			}
+			if delta != "" {
+				content.WriteString(delta)
+				onToken(delta)
+			}
+		case "message_stop":
+			return content.String(), nil
+		case "error":
+			if event.Error != nil {
+				return "", anthropicAIError(0, event.Error, 0)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("ошибка чтения потока от Anthropic: %w", err)
+	}
+	return "", fmt.Errorf("Anthropic вернул неполный ответ")
+}
+
+// send отправляет запрос к /v1/messages и возвращает ответ с проверенным статусом
+func (c *AnthropicClient) send(ctx context.Context, body *AnthropicRequest) (*http.Response, error) {
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при маршалинге запроса к Anthropic: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/v1/messages", bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания запроса к Anthropic: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", c.apiKey)
+	req.Header.Set("anthropic-version", anthropicVersion)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, connectionError("Anthropic", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		var errBody struct {
+			Error *anthropicError `json:"error"`
+		}
+		if json.Unmarshal(bodyBytes, &errBody) != nil || errBody.Error == nil {
+			errBody.Error = &anthropicError{Message: string(bodyBytes)}
+		}
+		return nil, anthropicAIError(resp.StatusCode, errBody.Error, apperr.ParseRetryAfter(resp.Header.Get("retry-after")))
+	}
+
+	return resp, nil
+}
+
+// anthropicAIError превращает ошибку Anthropic в AIError. 529 (overloaded)
+// отдается как 503: для клиента это та же временная недоступность сервиса.
+// Ошибки внутри потока приходят без HTTP статуса, он определяется по типу.
+func anthropicAIError(status int, apiErr *anthropicError, retryAfter int) *AIError {
+	switch apiErr.Type {
+	case "rate_limit_error":
+		status = http.StatusTooManyRequests
+	case "overloaded_error":
+		status = 529
+	case "api_error":
+		if status == 0 {
+			status = http.StatusInternalServerError
+		}
+	}
+	if status == 0 {
+		status = http.StatusBadGateway
+	}
+
+	aiErr := &AIError{
+		StatusCode: status,
+		Message:    fmt.Sprintf("Anthropic вернул ошибку %d: %s", status, apiErr.Message),
+		RetryAfter: retryAfter,
+	}
+	switch status {
+	case http.StatusTooManyRequests:
+		if aiErr.RetryAfter == 0 {
+			aiErr.RetryAfter = 10
+		}
+	case 529:
+		aiErr.StatusCode = http.StatusServiceUnavailable
+		if aiErr.RetryAfter == 0 {
+			aiErr.RetryAfter = 30
+		}
+	}
+	return aiErr
+}
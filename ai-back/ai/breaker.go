@@ -0,0 +1,200 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BreakerState - состояние предохранителя
+type BreakerState string
+
+const (
+	// BreakerClosed - запросы идут к провайдеру
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen - провайдер считается недоступным, запросы сразу отклоняются
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen - пропускается один пробный запрос
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// BreakerConfig - настройки предохранителя
+type BreakerConfig struct {
+	FailureThreshold int           // ошибок подряд до размыкания
+	OpenTimeout      time.Duration // сколько ждать до пробного запроса
+}
+
+// DefaultBreakerConfig - настройки предохранителя по умолчанию
+func DefaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		FailureThreshold: 5,
+		OpenTimeout:      30 * time.Second,
+	}
+}
+
+// CircuitBreaker - предохранитель вокруг AIClient. После FailureThreshold
+// ошибок подряд (5xx, таймаут, нет соединения) размыкается и сразу
+// отвечает 503, не дожидаясь таймаута провайдера. Через OpenTimeout
+// пропускает один пробный запрос: успех замыкает предохранитель, ошибка
+// снова размыкает. Ответы 4xx и 429 означают, что провайдер жив, и
+// ошибкой не считаются.
+type CircuitBreaker struct {
+	name   string
+	client AIClient
+	cfg    BreakerConfig
+
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	lastError string
+}
+
+// BreakerStatus - состояние предохранителя для эндпоинта статуса
+type BreakerStatus struct {
+	Provider            string       `json:"provider"`
+	State               BreakerState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutiveFailures"`
+	OpenedAt            *time.Time   `json:"openedAt,omitempty"`
+	RetryAfter          int          `json:"retryAfter,omitempty"` // секунды до пробного запроса
+	LastError           string       `json:"lastError,omitempty"`
+}
+
+// NewCircuitBreaker оборачивает client предохранителем; name - имя
+// провайдера в ошибках и статусе
+func NewCircuitBreaker(name string, client AIClient, cfg BreakerConfig) *CircuitBreaker {
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = DefaultBreakerConfig().FailureThreshold
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = DefaultBreakerConfig().OpenTimeout
+	}
+	return &CircuitBreaker{
+		name:   name,
+		client: client,
+		cfg:    cfg,
+		state:  BreakerClosed,
+	}
+}
+
+// AnalyzeTrace - анализ через провайдера, если предохранитель замкнут
+func (b *CircuitBreaker) AnalyzeTrace(ctx context.Context, req *AnalysisRequest) (string, error) {
+	if err := b.allow(); err != nil {
+		return "", err
+	}
+	content, err := b.client.AnalyzeTrace(ctx, req)
+	b.record(ctx, err)
+	return content, err
+}
+
+// AnalyzeTraceStream - потоковый анализ через провайдера, если предохранитель замкнут
+func (b *CircuitBreaker) AnalyzeTraceStream(ctx context.Context, req *AnalysisRequest, onToken func(string)) (string, error) {
+	if err := b.allow(); err != nil {
+		return "", err
+	}
+	content, err := b.client.AnalyzeTraceStream(ctx, req, onToken)
+	b.record(ctx, err)
+	return content, err
+}
+
+// Close закрывает вложенного клиента
+func (b *CircuitBreaker) Close() error {
+	return Close(b.client)
+}
+
+// Status возвращает текущее состояние предохранителя
+func (b *CircuitBreaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{
+		Provider:            b.name,
+		State:               b.currentState(),
+		ConsecutiveFailures: b.failures,
+		LastError:           b.lastError,
+	}
+	if status.State != BreakerClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	if status.State == BreakerOpen {
+		status.RetryAfter = b.retryAfter()
+	}
+	return status
+}
+
+// allow решает, пропустить ли запрос к провайдеру
+func (b *CircuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.currentState() {
+	case BreakerClosed:
+		return nil
+	case BreakerHalfOpen:
+		if !b.probing {
+			b.probing = true
+			b.state = BreakerHalfOpen
+			log.Printf("🔌 Предохранитель %s: пробный запрос", b.name)
+			return nil
+		}
+	}
+	return &AIError{
+		StatusCode: http.StatusServiceUnavailable,
+		Message: fmt.Sprintf("провайдер %s временно отключен после %d ошибок подряд, последняя: %s",
+			b.name, b.failures, b.lastError),
+		RetryAfter: b.retryAfter(),
+	}
+}
+
+// record учитывает результат запроса. Отмена запроса клиентом ничего
+// не говорит о провайдере и не учитывается.
+func (b *CircuitBreaker) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	probe := b.probing
+	b.probing = false
+
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+	if err == nil || !isUnavailable(err) {
+		if b.state != BreakerClosed {
+			log.Printf("🔌 Предохранитель %s замкнут: провайдер снова отвечает", b.name)
+		}
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	b.lastError = err.Error()
+	if probe || b.failures >= b.cfg.FailureThreshold {
+		if b.state != BreakerOpen {
+			log.Printf("🔌 Предохранитель %s разомкнут на %s после %d ошибок подряд: %v",
+				b.name, b.cfg.OpenTimeout, b.failures, err)
+		}
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// currentState - состояние с учетом истекшего OpenTimeout; вызывается под mu
+func (b *CircuitBreaker) currentState() BreakerState {
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.cfg.OpenTimeout {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// retryAfter - секунды до пробного запроса, не меньше 1; вызывается под mu
+func (b *CircuitBreaker) retryAfter() int {
+	remaining := b.cfg.OpenTimeout - time.Since(b.openedAt)
+	return int(math.Max(1, math.Ceil(remaining.Seconds())))
+}
@@ -0,0 +1,221 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scriptedClient - провайдер, который отвечает заранее заданными ошибками
+// по порядку (nil - успех); после конца списка отвечает успехом. Если задан
+// block, каждый вызов ждет значения из него.
+type scriptedClient struct {
+	mu     sync.Mutex
+	errs   []error
+	calls  int
+	block  chan struct{}
+	tokens []string // отправляются в onToken перед ответом потока
+}
+
+func (c *scriptedClient) next() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	if len(c.errs) == 0 {
+		return nil
+	}
+	err := c.errs[0]
+	c.errs = c.errs[1:]
+	return err
+}
+
+func (c *scriptedClient) callCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+func (c *scriptedClient) AnalyzeTrace(ctx context.Context, req *AnalysisRequest) (string, error) {
+	err := c.next()
+	if c.block != nil {
+		<-c.block
+	}
+	if err != nil {
+		return "", err
+	}
+	return "{}", nil
+}
+
+func (c *scriptedClient) AnalyzeTraceStream(ctx context.Context, req *AnalysisRequest, onToken func(string)) (string, error) {
+	err := c.next()
+	for _, token := range c.tokens {
+		onToken(token)
+	}
+	if err != nil {
+		return "", err
+	}
+	return "{}", nil
+}
+
+func unavailable() error {
+	return &AIError{StatusCode: http.StatusServiceUnavailable, Message: "сервис недоступен"}
+}
+
+// repeatErr - n одинаковых ошибок
+func repeatErr(n int, newErr func() error) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = newErr()
+	}
+	return errs
+}
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	client := &scriptedClient{errs: repeatErr(3, unavailable)}
+	breaker := NewCircuitBreaker("test/model", client, BreakerConfig{FailureThreshold: 3, OpenTimeout: time.Hour})
+	ctx := context.Background()
+
+	for i := 1; i <= 3; i++ {
+		if _, err := breaker.AnalyzeTrace(ctx, &AnalysisRequest{}); err == nil {
+			t.Fatalf("call %d: want provider error", i)
+		}
+		want := BreakerClosed
+		if i == 3 {
+			want = BreakerOpen
+		}
+		if state := breaker.Status().State; state != want {
+			t.Fatalf("after %d failures state = %s, want %s", i, state, want)
+		}
+	}
+
+	_, err := breaker.AnalyzeTrace(ctx, &AnalysisRequest{})
+	var aiErr *AIError
+	if !errors.As(err, &aiErr) || aiErr.StatusCode != http.StatusServiceUnavailable || aiErr.RetryAfter <= 0 {
+		t.Fatalf("open breaker error = %v, want 503 with retryAfter", err)
+	}
+	if client.callCount() != 3 {
+		t.Errorf("provider calls = %d, want 3: open breaker must not call the provider", client.callCount())
+	}
+
+	status := breaker.Status()
+	if status.ConsecutiveFailures != 3 || status.OpenedAt == nil || status.LastError == "" {
+		t.Errorf("status = %+v, want 3 failures, openedAt and lastError", status)
+	}
+}
+
+func TestBreakerSuccessResetsFailures(t *testing.T) {
+	client := &scriptedClient{errs: []error{unavailable(), unavailable(), nil, unavailable(), unavailable()}}
+	breaker := NewCircuitBreaker("test/model", client, BreakerConfig{FailureThreshold: 3, OpenTimeout: time.Hour})
+
+	for i := 0; i < 5; i++ {
+		breaker.AnalyzeTrace(context.Background(), &AnalysisRequest{})
+	}
+	if status := breaker.Status(); status.State != BreakerClosed || status.ConsecutiveFailures != 2 {
+		t.Errorf("status = %+v, want closed with 2 consecutive failures", status)
+	}
+}
+
+func TestBreakerIgnoresClientErrors(t *testing.T) {
+	errs := []error{
+		&AIError{StatusCode: http.StatusTooManyRequests, Message: "rate limit", RetryAfter: 10},
+		&AIError{StatusCode: http.StatusBadRequest, Message: "bad request"},
+		&AIError{StatusCode: http.StatusUnauthorized, Message: "invalid key"},
+		&AIError{StatusCode: http.StatusPaymentRequired, Message: "no credits"},
+		&AIError{StatusCode: http.StatusNotFound, Message: "no model"},
+	}
+	client := &scriptedClient{errs: errs}
+	breaker := NewCircuitBreaker("test/model", client, BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Hour})
+
+	for range errs {
+		if _, err := breaker.AnalyzeTrace(context.Background(), &AnalysisRequest{}); err == nil {
+			t.Fatal("want provider error")
+		}
+		if status := breaker.Status(); status.State != BreakerClosed || status.ConsecutiveFailures != 0 {
+			t.Fatalf("status = %+v, want closed: 4xx and 429 mean the provider is alive", status)
+		}
+	}
+	if client.callCount() != len(errs) {
+		t.Errorf("provider calls = %d, want %d", client.callCount(), len(errs))
+	}
+}
+
+func TestBreakerIgnoresCanceledRequests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	client := &scriptedClient{errs: []error{context.Canceled}}
+	breaker := NewCircuitBreaker("test/model", client, BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Hour})
+
+	breaker.AnalyzeTrace(ctx, &AnalysisRequest{})
+	if state := breaker.Status().State; state != BreakerClosed {
+		t.Errorf("state = %s, want closed: client cancellation says nothing about the provider", state)
+	}
+}
+
+func TestBreakerHalfOpenAllowsOneProbe(t *testing.T) {
+	const openTimeout = 30 * time.Millisecond
+	client := &scriptedClient{errs: []error{unavailable()}}
+	breaker := NewCircuitBreaker("test/model", client, BreakerConfig{FailureThreshold: 1, OpenTimeout: openTimeout})
+	ctx := context.Background()
+
+	breaker.AnalyzeTrace(ctx, &AnalysisRequest{})
+	if state := breaker.Status().State; state != BreakerOpen {
+		t.Fatalf("state = %s, want open", state)
+	}
+
+	time.Sleep(openTimeout + 10*time.Millisecond)
+	if state := breaker.Status().State; state != BreakerHalfOpen {
+		t.Fatalf("state after open timeout = %s, want half_open", state)
+	}
+
+	// Пробный запрос зависает у провайдера; остальные в это время отклоняются
+	client.block = make(chan struct{})
+	probeDone := make(chan error)
+	go func() {
+		_, err := breaker.AnalyzeTrace(ctx, &AnalysisRequest{})
+		probeDone <- err
+	}()
+	for client.callCount() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := breaker.AnalyzeTrace(ctx, &AnalysisRequest{})
+		var aiErr *AIError
+		if !errors.As(err, &aiErr) || aiErr.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("request during probe: err = %v, want 503", err)
+		}
+	}
+	if client.callCount() != 2 {
+		t.Fatalf("provider calls = %d, want 2: only one probe may reach the provider", client.callCount())
+	}
+
+	close(client.block)
+	if err := <-probeDone; err != nil {
+		t.Fatalf("probe error = %v", err)
+	}
+	client.block = nil
+	if status := breaker.Status(); status.State != BreakerClosed || status.ConsecutiveFailures != 0 {
+		t.Errorf("status after successful probe = %+v, want closed", status)
+	}
+}
+
+func TestBreakerFailedProbeReopens(t *testing.T) {
+	const openTimeout = 30 * time.Millisecond
+	client := &scriptedClient{errs: []error{unavailable(), unavailable()}}
+	breaker := NewCircuitBreaker("test/model", client, BreakerConfig{FailureThreshold: 1, OpenTimeout: openTimeout})
+	ctx := context.Background()
+
+	breaker.AnalyzeTrace(ctx, &AnalysisRequest{})
+	time.Sleep(openTimeout + 10*time.Millisecond)
+	breaker.AnalyzeTrace(ctx, &AnalysisRequest{})
+
+	if state := breaker.Status().State; state != BreakerOpen {
+		t.Fatalf("state after failed probe = %s, want open", state)
+	}
+	if _, err := breaker.AnalyzeTrace(ctx, &AnalysisRequest{}); err == nil || client.callCount() != 2 {
+		t.Errorf("err = %v, calls = %d: want reopened breaker to reject without calling the provider", err, client.callCount())
+	}
+}
@@ -0,0 +1,195 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"langfuse-analyzer-backend/apperr"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Checker - клиент, который умеет дешево проверить, что провайдер
+// доступен, принимает ключ и знает настроенную модель, не запуская анализ
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// ErrCheckUnsupported - клиент не умеет проверять провайдера
+var ErrCheckUnsupported = errors.New("проверка провайдера не поддерживается")
+
+// Check проверяет провайдера client, если тот реализует Checker
+func Check(ctx context.Context, client AIClient) error {
+	if checker, ok := client.(Checker); ok {
+		return checker.Check(ctx)
+	}
+	return ErrCheckUnsupported
+}
+
+// Check проверяет, что Ollama запущена и модель загружена (GET /api/tags)
+func (c *OllamaClient) Check(ctx context.Context) error {
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	err := probe(ctx, c.client, "Ollama", c.baseURL+"/api/tags", nil, &tags, func(status int, body []byte) *AIError {
+		return &AIError{
+			StatusCode: status,
+			Message:    fmt.Sprintf("Ollama вернула ошибку %d: %s", status, string(body)),
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// Модель без тега Ollama хранит как "<модель>:latest"
+	for _, m := range tags.Models {
+		if m.Name == c.model || (!strings.Contains(c.model, ":") && m.Name == c.model+":latest") {
+			return nil
+		}
+	}
+	return &AIError{
+		StatusCode: http.StatusNotFound,
+		Message:    fmt.Sprintf("модель %s не загружена в Ollama, выполните: ollama pull %s", c.model, c.model),
+	}
+}
+
+// Check проверяет провайдера с OpenAI API. Для OpenRouter - ключ и
+// остаток кредитов (GET /key), для остальных - список моделей: у
+// openai-compatible сервера в нем должна быть настроенная модель, у Azure
+// список содержит базовые модели, а не deployment, и только проверяет ключ.
+func (c *OpenAIClient) Check(ctx context.Context) error {
+	if c.provider == ProviderOpenRouter {
+		return c.checkOpenRouterKey(ctx)
+	}
+
+	ctx, hint := withRetryHint(ctx)
+	models, err := c.client.ListModels(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return mapOpenAIError(err, hint.seconds)
+	}
+	if c.provider == ProviderAzure || len(models.Models) == 0 {
+		return nil
+	}
+	if !slices.ContainsFunc(models.Models, func(m openai.Model) bool { return m.ID == c.model }) {
+		return &AIError{
+			StatusCode: http.StatusNotFound,
+			Message:    fmt.Sprintf("модель %s не найдена в списке моделей сервера", c.model),
+		}
+	}
+	return nil
+}
+
+// checkOpenRouterKey проверяет ключ OpenRouter и остаток кредитов
+func (c *OpenAIClient) checkOpenRouterKey(ctx context.Context) error {
+	var key struct {
+		Data struct {
+			LimitRemaining *float64 `json:"limit_remaining"`
+		} `json:"data"`
+	}
+	header := http.Header{"Authorization": {"Bearer " + c.apiKey}}
+	err := probe(ctx, c.httpClient, "OpenRouter", strings.TrimRight(c.baseURL, "/")+"/key", header, &key, func(status int, body []byte) *AIError {
+		return &AIError{
+			StatusCode: status,
+			Message:    fmt.Sprintf("OpenRouter вернул ошибку %d: %s", status, string(body)),
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if key.Data.LimitRemaining != nil && *key.Data.LimitRemaining <= 0 {
+		return &AIError{
+			StatusCode: http.StatusPaymentRequired,
+			Message:    "у ключа OpenRouter исчерпан лимит кредитов",
+		}
+	}
+	return nil
+}
+
+// Check проверяет ключ и модель Anthropic (GET /v1/models/{model})
+func (c *AnthropicClient) Check(ctx context.Context) error {
+	header := http.Header{
+		"X-Api-Key":         {c.apiKey},
+		"Anthropic-Version": {anthropicVersion},
+	}
+	var model struct{}
+	return probe(ctx, c.client, "Anthropic", c.baseURL+"/v1/models/"+url.PathEscape(c.model), header, &model, func(status int, body []byte) *AIError {
+		var errBody struct {
+			Error *anthropicError `json:"error"`
+		}
+		if json.Unmarshal(body, &errBody) != nil || errBody.Error == nil {
+			errBody.Error = &anthropicError{Message: string(body)}
+		}
+		return anthropicAIError(status, errBody.Error, 0)
+	})
+}
+
+// Check проверяет ключ и модель Gemini (GET /v1beta/models/{model})
+func (c *GeminiClient) Check(ctx context.Context) error {
+	header := http.Header{"X-Goog-Api-Key": {c.apiKey}}
+	var model struct{}
+	return probe(ctx, c.client, "Gemini", c.baseURL+"/v1beta/models/"+url.PathEscape(c.model), header, &model, geminiAIError)
+}
+
+// probe выполняет GET запрос проверки и разбирает успешный ответ в out.
+// Ответ с ошибкой превращается в AIError функцией errorOf.
+func probe(ctx context.Context, client *http.Client, provider, endpoint string, header http.Header, out any, errorOf func(status int, body []byte) *AIError) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("ошибка создания запроса к %s: %w", provider, err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &AIError{
+			StatusCode: http.StatusServiceUnavailable,
+			Message:    fmt.Sprintf("ошибка при подключении к %s: %v", provider, err),
+		}
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		aiErr := errorOf(resp.StatusCode, body)
+		if aiErr.RetryAfter == 0 {
+			aiErr.RetryAfter = apperr.ParseRetryAfter(resp.Header.Get("Retry-After"))
+		}
+		return aiErr
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return &AIError{
+			StatusCode: http.StatusBadGateway,
+			Message:    fmt.Sprintf("%s вернул некорректный ответ: %v", provider, err),
+		}
+	}
+	return nil
+}
+
+// Check проверяет провайдера вложенного клиента
+func (c *RetryClient) Check(ctx context.Context) error {
+	return Check(ctx, c.client)
+}
+
+// Check проверяет провайдера вложенного клиента. Результат проверки не
+// влияет на состояние предохранителя: его меняют только запросы анализа.
+func (b *CircuitBreaker) Check(ctx context.Context) error {
+	return Check(ctx, b.client)
+}
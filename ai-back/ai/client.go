@@ -7,12 +7,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 
+	"langfuse-analyzer-backend/apperr"
+
 	"github.com/sashabaranov/go-openai"
 )
 
@@ -30,9 +32,54 @@ func (e *AIError) Error() string {
 	return e.Message
 }
 
+// AppError относит ошибку провайдера к таксономии API по HTTP статусу
+func (e *AIError) AppError() *apperr.Error {
+	appErr := &apperr.Error{RetryAfter: e.RetryAfter, Err: e}
+	switch {
+	case e.StatusCode == http.StatusTooManyRequests:
+		appErr.Code = apperr.CodeRateLimited
+		appErr.Message = "Слишком много запросов к AI. Попробуйте позже."
+	case e.StatusCode == http.StatusPaymentRequired:
+		appErr.Code = apperr.CodeInsufficientCredits
+		appErr.Message = "Недостаточно кредитов для AI анализа. Пополните баланс у AI провайдера."
+	case e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden:
+		appErr.Code = apperr.CodeAuthFailed
+		appErr.Message = "AI провайдер отклонил ключ API. Проверьте настройки провайдера."
+	case e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusGatewayTimeout:
+		appErr.Code = apperr.CodeTimeout
+		appErr.Message = "AI провайдер не ответил вовремя"
+	case e.StatusCode >= 500:
+		appErr.Code = apperr.CodeUpstreamUnavailable
+		appErr.Message = "AI провайдер недоступен: " + e.Message
+	default:
+		appErr.Code = apperr.CodeUpstreamError
+		appErr.Message = "AI провайдер вернул ошибку: " + e.Message
+	}
+	return appErr
+}
+
+// Is позволяет сравнивать AIError с образцами apperr через errors.Is
+func (e *AIError) Is(target error) bool {
+	return e.AppError().Is(target)
+}
+
 // AIClient - интерфейс для работы с различными AI провайдерами
 type AIClient interface {
-	AnalyzeTrace(ctx context.Context, traceData map[string]interface{}) (string, error)
+	// AnalyzeTrace анализирует трейс или сессию, описанные в req
+	AnalyzeTrace(ctx context.Context, req *AnalysisRequest) (string, error)
+	// AnalyzeTraceStream работает как AnalyzeTrace, но вызывает onToken для
+	// каждого фрагмента ответа по мере его генерации. Возвращает полный ответ.
+	AnalyzeTraceStream(ctx context.Context, req *AnalysisRequest, onToken func(string)) (string, error)
+}
+
+// Close закрывает client, если он держит ресурсы (io.Closer): HTTP-клиенты
+// провайдеров закрывают простаивающие соединения, обертки закрывают
+// вложенных клиентов
+func Close(client AIClient) error {
+	if closer, ok := client.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
 }
 
 // ProviderType - тип провайдера AI
@@ -41,13 +88,62 @@ type ProviderType string
 const (
 	ProviderOpenRouter ProviderType = "openrouter"
 	ProviderOllama     ProviderType = "ollama"
+	ProviderAnthropic  ProviderType = "anthropic"
+	ProviderGemini     ProviderType = "gemini"
+	ProviderAzure      ProviderType = "azure"
+	// ProviderOpenAICompatible - любой сервер с OpenAI-совместимым API
+	// (vLLM, llama.cpp server, LM Studio и т.п.)
+	ProviderOpenAICompatible ProviderType = "openai-compatible"
 )
 
+// Capabilities - возможности OpenAI-совместимого сервера
+type Capabilities struct {
+	JSONMode   bool // поддерживает response_format: json_object
+	SystemRole bool // поддерживает сообщения с ролью system
+	// StreamUsage - поддерживает stream_options.include_usage: расход
+	// токенов приходит последним фрагментом потока
+	StreamUsage bool
+}
+
+// fullCapabilities - возможности OpenRouter и Azure OpenAI
+var fullCapabilities = Capabilities{JSONMode: true, SystemRole: true, StreamUsage: true}
+
+// Config - настройки AI клиента. Поля, не относящиеся к выбранному
+// провайдеру, игнорируются.
+type Config struct {
+	Provider  ProviderType
+	APIKey    string
+	BaseURL   string
+	Model     string
+	MaxTokens int
+
+	// AnthropicJSONMode - способ получить JSON от Claude:
+	// AnthropicJSONPrefill (по умолчанию) или AnthropicJSONTool
+	AnthropicJSONMode string
+
+	// AzureAPIVersion - версия Azure OpenAI API (параметр api-version).
+	// Для Azure BaseURL - endpoint ресурса, Model - имя deployment.
+	AzureAPIVersion string
+
+	// ExtraHeaders - дополнительные заголовки запросов к openai-compatible серверу
+	ExtraHeaders map[string]string
+	// Capabilities - возможности openai-compatible сервера
+	Capabilities Capabilities
+
+	// Timeout - таймаут запроса к Ollama, Anthropic и Gemini; 0 - 120 секунд
+	Timeout time.Duration
+}
+
 // OpenAIClient - клиент для работы с OpenAI-совместимыми API (OpenRouter)
 type OpenAIClient struct {
-	client    *openai.Client
-	model     string
-	maxTokens int
+	client     *openai.Client
+	httpClient *http.Client
+	provider   ProviderType
+	baseURL    string
+	apiKey     string
+	model      string
+	maxTokens  int
+	caps       Capabilities
 }
 
 // OllamaClient - клиент для работы с Ollama
@@ -58,23 +154,90 @@ type OllamaClient struct {
 	client    *http.Client
 }
 
-// headerTransport добавляет кастомные заголовки для OpenRouter
+// headerTransport добавляет к запросам дополнительные заголовки
 type headerTransport struct {
-	base http.RoundTripper
+	base    http.RoundTripper
+	headers map[string]string
 }
 
 func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	req.Header.Add("HTTP-Referer", "http://localhost")
+	req = req.Clone(req.Context())
+	for name, value := range t.headers {
+		req.Header.Set(name, value)
+	}
 	return t.base.RoundTrip(req)
 }
 
+func (t *headerTransport) CloseIdleConnections() {
+	closeIdleConnections(t.base)
+}
+
+// retryHint - значение заголовка Retry-After ответа с ошибкой, в секундах
+type retryHint struct {
+	seconds int
+}
+
+type retryHintKey struct{}
+
+// withRetryHint возвращает контекст, в который retryAfterTransport
+// запишет Retry-After ответа с ошибкой
+func withRetryHint(ctx context.Context) (context.Context, *retryHint) {
+	hint := &retryHint{}
+	return context.WithValue(ctx, retryHintKey{}, hint), hint
+}
+
+// retryAfterTransport запоминает заголовок Retry-After ответов с ошибкой
+// в retryHint из контекста запроса: go-openai не отдает заголовки ответа
+type retryAfterTransport struct {
+	base http.RoundTripper
+}
+
+func (t *retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err == nil && resp.StatusCode >= http.StatusBadRequest {
+		if hint, ok := req.Context().Value(retryHintKey{}).(*retryHint); ok {
+			hint.seconds = apperr.ParseRetryAfter(resp.Header.Get("Retry-After"))
+		}
+	}
+	return resp, err
+}
+
+func (t *retryAfterTransport) CloseIdleConnections() {
+	closeIdleConnections(t.base)
+}
+
+// closeIdleConnections закрывает простаивающие соединения транспорта, если он это умеет
+func closeIdleConnections(transport http.RoundTripper) {
+	if t, ok := transport.(interface{ CloseIdleConnections() }); ok {
+		t.CloseIdleConnections()
+	}
+}
+
+// openAIHTTPClient - HTTP-клиент для go-openai: добавляет к запросам
+// headers и запоминает Retry-After ответов с ошибкой
+func openAIHTTPClient(headers map[string]string) *http.Client {
+	var transport http.RoundTripper = http.DefaultTransport
+	if len(headers) > 0 {
+		transport = &headerTransport{base: transport, headers: headers}
+	}
+	return &http.Client{Transport: &retryAfterTransport{base: transport}}
+}
+
 // NewAIClient создает подходящего клиента на основе конфигурации
-func NewAIClient(provider ProviderType, apiKey, baseURL, model string, maxTokens int) AIClient {
-	switch provider {
+func NewAIClient(cfg Config) AIClient {
+	switch cfg.Provider {
 	case ProviderOllama:
-		return NewOllamaClient(baseURL, model, maxTokens)
+		return NewOllamaClient(cfg.BaseURL, cfg.Model, cfg.MaxTokens, cfg.Timeout)
+	case ProviderAnthropic:
+		return NewAnthropicClient(cfg.APIKey, cfg.BaseURL, cfg.Model, cfg.MaxTokens, cfg.AnthropicJSONMode, cfg.Timeout)
+	case ProviderGemini:
+		return NewGeminiClient(cfg.APIKey, cfg.BaseURL, cfg.Model, cfg.MaxTokens, cfg.Timeout)
+	case ProviderAzure:
+		return NewAzureOpenAIClient(cfg.APIKey, cfg.BaseURL, cfg.Model, cfg.AzureAPIVersion, cfg.MaxTokens)
+	case ProviderOpenAICompatible:
+		return NewOpenAICompatibleClient(cfg.APIKey, cfg.BaseURL, cfg.Model, cfg.MaxTokens, cfg.ExtraHeaders, cfg.Capabilities)
 	default:
-		return NewOpenAIClient(apiKey, baseURL, model, maxTokens)
+		return NewOpenAIClient(cfg.APIKey, cfg.BaseURL, cfg.Model, cfg.MaxTokens)
 	}
 }
 
@@ -86,12 +249,7 @@ func NewOpenAIClient(apiKey, baseURL, model string, maxTokens int) *OpenAIClient
 	}
 
 	// Создаем кастомный HTTP-клиент с нужными заголовками для OpenRouter
-	transport := &headerTransport{
-		base: http.DefaultTransport,
-	}
-	httpClient := &http.Client{
-		Transport: transport,
-	}
+	httpClient := openAIHTTPClient(map[string]string{"HTTP-Referer": "http://localhost"})
 	config.HTTPClient = httpClient
 
 	client := openai.NewClientWithConfig(config)
@@ -107,14 +265,77 @@ func NewOpenAIClient(apiKey, baseURL, model string, maxTokens int) *OpenAIClient
 	}
 
 	return &OpenAIClient{
-		client:    client,
-		model:     model,
-		maxTokens: maxTokens,
+		client:     client,
+		httpClient: httpClient,
+		provider:   ProviderOpenRouter,
+		baseURL:    config.BaseURL,
+		apiKey:     apiKey,
+		model:      model,
+		maxTokens:  maxTokens,
+		caps:       fullCapabilities,
+	}
+}
+
+// NewOpenAICompatibleClient создает клиента для произвольного сервера с
+// OpenAI-совместимым API. apiKey может быть пустым - тогда заголовок
+// Authorization не отправляется. headers добавляются к каждому запросу,
+// caps описывает, что сервер поддерживает.
+func NewOpenAICompatibleClient(apiKey, baseURL, model string, maxTokens int, headers map[string]string, caps Capabilities) *OpenAIClient {
+	config := openai.DefaultConfig(apiKey)
+	config.BaseURL = baseURL
+	httpClient := openAIHTTPClient(headers)
+	config.HTTPClient = httpClient
+
+	if maxTokens <= 0 {
+		maxTokens = 1000
+	}
+
+	return &OpenAIClient{
+		client:     openai.NewClientWithConfig(config),
+		httpClient: httpClient,
+		provider:   ProviderOpenAICompatible,
+		model:      model,
+		maxTokens:  maxTokens,
+		caps:       caps,
+	}
+}
+
+// DefaultAzureAPIVersion - версия Azure OpenAI API по умолчанию. С нее
+// поддерживается response_format json_object.
+const DefaultAzureAPIVersion = "2024-10-21"
+
+// NewAzureOpenAIClient создает клиента для Azure OpenAI. Запросы идут на
+// {endpoint}/openai/deployments/{deployment}/chat/completions?api-version=...
+// с ключом в заголовке api-key; ошибки разбираются так же, как у OpenRouter.
+func NewAzureOpenAIClient(apiKey, endpoint, deployment, apiVersion string, maxTokens int) *OpenAIClient {
+	config := openai.DefaultAzureConfig(apiKey, endpoint)
+	if apiVersion == "" {
+		apiVersion = DefaultAzureAPIVersion
+	}
+	config.APIVersion = apiVersion
+	// Имя deployment задано явно: преобразование имени модели по умолчанию не нужно
+	config.AzureModelMapperFunc = func(string) string {
+		return deployment
+	}
+	httpClient := openAIHTTPClient(nil)
+	config.HTTPClient = httpClient
+
+	if maxTokens <= 0 {
+		maxTokens = 1000
+	}
+
+	return &OpenAIClient{
+		client:     openai.NewClientWithConfig(config),
+		httpClient: httpClient,
+		provider:   ProviderAzure,
+		model:      deployment,
+		maxTokens:  maxTokens,
+		caps:       fullCapabilities,
 	}
 }
 
 // NewOllamaClient создает нового клиента для Ollama
-func NewOllamaClient(baseURL, model string, maxTokens int) *OllamaClient {
+func NewOllamaClient(baseURL, model string, maxTokens int, timeout time.Duration) *OllamaClient {
 	// Устанавливаем baseURL по умолчанию для Ollama
 	if baseURL == "" {
 		baseURL = "http://localhost:11434"
@@ -130,12 +351,9 @@ func NewOllamaClient(baseURL, model string, maxTokens int) *OllamaClient {
 		maxTokens = 1000
 	}
 
-	// Читаем таймаут из переменной окружения (если не указан - 120 секунд)
-	timeout := 120 * time.Second
-	if timeoutStr := os.Getenv("OLLAMA_TIMEOUT"); timeoutStr != "" {
-		if timeoutSec, err := strconv.Atoi(timeoutStr); err == nil && timeoutSec > 0 {
-			timeout = time.Duration(timeoutSec) * time.Second
-		}
+	// Устанавливаем таймаут по умолчанию
+	if timeout <= 0 {
+		timeout = 120 * time.Second
 	}
 
 	return &OllamaClient{
@@ -148,60 +366,80 @@ func NewOllamaClient(baseURL, model string, maxTokens int) *OllamaClient {
 	}
 }
 
-// AnalyzeTrace - анализ трейса через OpenRouter
-func (c *OpenAIClient) AnalyzeTrace(ctx context.Context, traceData map[string]interface{}) (string, error) {
-	traceStr, err := json.Marshal(traceData)
+// chatRequest формирует запрос ChatCompletion для анализа
+func (c *OpenAIClient) chatRequest(analysisReq *AnalysisRequest) (openai.ChatCompletionRequest, error) {
+	messages, err := buildMessages(analysisReq)
 	if err != nil {
-		return "", fmt.Errorf("ошибка при маршалинге traceData: %w", err)
-	}
-
-	systemPrompt := getSystemPrompt()
-	userPrompt := fmt.Sprintf("Проанализируй следующий JSON-трейс: %s", traceStr)
-
-	resp, err := c.client.CreateChatCompletion(
-		ctx,
-		openai.ChatCompletionRequest{
-			Model: c.model,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleSystem,
-					Content: systemPrompt,
-				},
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: userPrompt,
-				},
-			},
-			MaxTokens: c.maxTokens,
-			ResponseFormat: &openai.ChatCompletionResponseFormat{
-				Type: openai.ChatCompletionResponseFormatTypeJSONObject,
-			},
-		},
-	)
+		return openai.ChatCompletionRequest{}, err
+	}
 
-	if err != nil {
-		var apiErr *openai.APIError
-		if errors.As(err, &apiErr) {
-			aiErr := &AIError{
-				StatusCode: apiErr.HTTPStatusCode,
-				Message:    fmt.Sprintf("ошибка при вызове ChatCompletion: status %d, message: %s", apiErr.HTTPStatusCode, apiErr.Message),
-				RetryAfter: 0,
-			}
+	if !c.caps.SystemRole {
+		messages = mergeSystemMessages(messages)
+	}
 
-			if apiErr.HTTPStatusCode == 429 {
-				if retrySeconds := extractRetryAfter(apiErr.Message); retrySeconds > 0 {
-					aiErr.RetryAfter = retrySeconds
-				} else {
-					aiErr.RetryAfter = 10
-				}
-			}
+	chatMessages := make([]openai.ChatCompletionMessage, 0, len(messages))
+	for _, m := range messages {
+		chatMessages = append(chatMessages, openai.ChatCompletionMessage{
+			Role:    m.Role,
+			Content: m.Content,
+		})
+	}
 
-			return "", aiErr
+	req := openai.ChatCompletionRequest{
+		Model:     c.model,
+		Messages:  chatMessages,
+		MaxTokens: c.maxTokens,
+	}
+	// Без JSON mode формат задается только промптом, а JSON извлекается
+	// из ответа при разборе (ExtractJSON)
+	if c.caps.JSONMode {
+		req.ResponseFormat = &openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONObject,
 		}
+	}
+	return req, nil
+}
 
-		return "", fmt.Errorf("ошибка при вызове ChatCompletion: %w", err)
+// mergeSystemMessages переносит системные сообщения в начало первого
+// сообщения пользователя - для серверов и шаблонов чата без роли system
+func mergeSystemMessages(messages []Message) []Message {
+	var system []string
+	var merged []Message
+	for _, m := range messages {
+		if m.Role == "system" {
+			system = append(system, m.Content)
+			continue
+		}
+		if m.Role == "user" && len(system) > 0 {
+			m.Content = strings.Join(append(system, m.Content), "\n\n")
+			system = nil
+		}
+		merged = append(merged, m)
+	}
+	return merged
+}
+
+// Close закрывает простаивающие соединения клиента
+func (c *OpenAIClient) Close() error {
+	c.httpClient.CloseIdleConnections()
+	return nil
+}
+
+// AnalyzeTrace - анализ трейса через OpenRouter
+func (c *OpenAIClient) AnalyzeTrace(ctx context.Context, analysisReq *AnalysisRequest) (string, error) {
+	req, err := c.chatRequest(analysisReq)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, hint := withRetryHint(ctx)
+	resp, err := c.client.CreateChatCompletion(ctx, req)
+	if err != nil {
+		return "", mapOpenAIError(err, hint.seconds)
 	}
 
+	recordUsage(ctx, resp.Usage.PromptTokens, resp.Usage.CompletionTokens)
+
 	if len(resp.Choices) == 0 {
 		return "", fmt.Errorf("нет ответа от AI")
 	}
@@ -209,6 +447,110 @@ func (c *OpenAIClient) AnalyzeTrace(ctx context.Context, traceData map[string]in
 	return resp.Choices[0].Message.Content, nil
 }
 
+// AnalyzeTraceStream - потоковый анализ трейса через OpenRouter
+func (c *OpenAIClient) AnalyzeTraceStream(ctx context.Context, analysisReq *AnalysisRequest, onToken func(string)) (string, error) {
+	req, err := c.chatRequest(analysisReq)
+	if err != nil {
+		return "", err
+	}
+	req.Stream = true
+	// Без include_usage OpenAI-совместимые API не сообщают расход токенов в потоке
+	if c.caps.StreamUsage {
+		req.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	}
+
+	ctx, hint := withRetryHint(ctx)
+	stream, err := c.client.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return "", mapOpenAIError(err, hint.seconds)
+	}
+	defer stream.Close()
+
+	var content strings.Builder
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", mapOpenAIError(err, 0)
+		}
+		// Расход токенов приходит в последнем фрагменте без choices
+		if chunk.Usage != nil {
+			recordUsage(ctx, chunk.Usage.PromptTokens, chunk.Usage.CompletionTokens)
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+
+		delta := chunk.Choices[0].Delta.Content
+		if delta == "" {
+			continue
+		}
+		content.WriteString(delta)
+		onToken(delta)
+	}
+
+	if content.Len() == 0 {
+		return "", fmt.Errorf("нет ответа от AI")
+	}
+
+	return content.String(), nil
+}
+
+// connectionError - ошибка запроса к провайдеру, на который не пришел
+// ответ. Истекший таймаут http.Client - 504 (CodeTimeout), как у Langfuse,
+// остальное (нет соединения, обрыв) - 503. Оба статуса продолжают цепочку
+// резервных провайдеров.
+func connectionError(provider string, err error) *AIError {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return &AIError{
+			StatusCode: http.StatusGatewayTimeout,
+			Message:    fmt.Sprintf("%s не ответил вовремя: %v", provider, err),
+		}
+	}
+	return &AIError{
+		StatusCode: http.StatusServiceUnavailable,
+		Message:    fmt.Sprintf("ошибка при подключении к %s: %v", provider, err),
+	}
+}
+
+// mapOpenAIError превращает ошибку go-openai в AIError, если известен HTTP
+// статус. retryAfter - значение заголовка Retry-After; если его нет, время
+// ожидания ищется в тексте ошибки.
+func mapOpenAIError(err error, retryAfter int) error {
+	var status int
+	var message string
+	var apiErr *openai.APIError
+	var reqErr *openai.RequestError
+	switch {
+	case errors.As(err, &apiErr):
+		status, message = apiErr.HTTPStatusCode, apiErr.Message
+	case errors.As(err, &reqErr):
+		status, message = reqErr.HTTPStatusCode, string(reqErr.Body)
+	}
+	if status == 0 {
+		return fmt.Errorf("ошибка при вызове ChatCompletion: %w", err)
+	}
+
+	aiErr := &AIError{
+		StatusCode: status,
+		Message:    fmt.Sprintf("ошибка при вызове ChatCompletion: status %d, message: %s", status, message),
+		RetryAfter: retryAfter,
+	}
+
+	if status == 429 && aiErr.RetryAfter == 0 {
+		if retrySeconds := extractRetryAfter(message); retrySeconds > 0 {
+			aiErr.RetryAfter = retrySeconds
+		} else {
+			aiErr.RetryAfter = 10
+		}
+	}
+
+	return aiErr
+}
+
 // OllamaRequest - структура запроса к Ollama API
 type OllamaRequest struct {
 	Model    string          `json:"model"`
@@ -235,33 +577,92 @@ type OllamaResponse struct {
 	CreatedAt string        `json:"created_at"`
 	Message   OllamaMessage `json:"message"`
 	Done      bool          `json:"done"`
+
+	// Расход токенов, только в последнем ответе (done = true)
+	PromptEvalCount int `json:"prompt_eval_count"`
+	EvalCount       int `json:"eval_count"`
+}
+
+// Close закрывает простаивающие соединения клиента
+func (c *OllamaClient) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
 }
 
 // AnalyzeTrace - анализ трейса через Ollama
-func (c *OllamaClient) AnalyzeTrace(ctx context.Context, traceData map[string]interface{}) (string, error) {
-	traceStr, err := json.Marshal(traceData)
+func (c *OllamaClient) AnalyzeTrace(ctx context.Context, analysisReq *AnalysisRequest) (string, error) {
+	resp, err := c.chat(ctx, analysisReq, false)
 	if err != nil {
-		return "", fmt.Errorf("ошибка при маршалинге traceData: %w", err)
+		return "", err
 	}
+	defer resp.Body.Close()
 
-	systemPrompt := getSystemPrompt()
-	userPrompt := fmt.Sprintf("Проанализируй следующий JSON-трейс: %s", traceStr)
+	// Читаем ответ
+	var ollamaResp OllamaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
+		return "", fmt.Errorf("ошибка декодирования ответа от Ollama: %w", err)
+	}
+
+	if !ollamaResp.Done {
+		return "", fmt.Errorf("Ollama вернула неполный ответ")
+	}
+	recordUsage(ctx, ollamaResp.PromptEvalCount, ollamaResp.EvalCount)
+
+	return ollamaResp.Message.Content, nil
+}
+
+// AnalyzeTraceStream - потоковый анализ трейса через Ollama.
+// Ollama отдает поток JSON-объектов OllamaResponse, по одному на строку.
+func (c *OllamaClient) AnalyzeTraceStream(ctx context.Context, analysisReq *AnalysisRequest, onToken func(string)) (string, error) {
+	resp, err := c.chat(ctx, analysisReq, true)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var content strings.Builder
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var chunk OllamaResponse
+		if err := decoder.Decode(&chunk); err != nil {
+			if errors.Is(err, io.EOF) {
+				return "", fmt.Errorf("Ollama вернула неполный ответ")
+			}
+			return "", fmt.Errorf("ошибка декодирования потока от Ollama: %w", err)
+		}
+
+		if chunk.Message.Content != "" {
+			content.WriteString(chunk.Message.Content)
+			onToken(chunk.Message.Content)
+		}
+		if chunk.Done {
+			recordUsage(ctx, chunk.PromptEvalCount, chunk.EvalCount)
+			return content.String(), nil
+		}
+	}
+}
+
+// chat отправляет запрос к /api/chat и возвращает ответ с проверенным статусом
+func (c *OllamaClient) chat(ctx context.Context, analysisReq *AnalysisRequest, stream bool) (*http.Response, error) {
+	messages, err := buildMessages(analysisReq)
+	if err != nil {
+		return nil, err
+	}
+
+	ollamaMessages := make([]OllamaMessage, 0, len(messages))
+	for _, m := range messages {
+		ollamaMessages = append(ollamaMessages, OllamaMessage{
+			Role:    m.Role,
+			Content: m.Content,
+		})
+	}
 
 	// Формируем запрос к Ollama
 	reqBody := OllamaRequest{
-		Model: c.model,
-		Messages: []OllamaMessage{
-			{
-				Role:    "system",
-				Content: systemPrompt,
-			},
-			{
-				Role:    "user",
-				Content: userPrompt,
-			},
-		},
-		Stream: false,
-		Format: "json", // Просим Ollama возвращать JSON
+		Model:    c.model,
+		Messages: ollamaMessages,
+		Stream:   stream,
+		Format:   "json", // Просим Ollama возвращать JSON
 		Options: &OllamaOptions{
 			NumPredict: c.maxTokens,
 		},
@@ -269,83 +670,39 @@ func (c *OllamaClient) AnalyzeTrace(ctx context.Context, traceData map[string]in
 
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", fmt.Errorf("ошибка при маршалинге запроса к Ollama: %w", err)
+		return nil, fmt.Errorf("ошибка при маршалинге запроса к Ollama: %w", err)
 	}
 
 	// Отправляем запрос к Ollama
 	url := fmt.Sprintf("%s/api/chat", c.baseURL)
 	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", fmt.Errorf("ошибка создания запроса к Ollama: %w", err)
+		return nil, fmt.Errorf("ошибка создания запроса к Ollama: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return "", &AIError{
+		return nil, &AIError{
 			StatusCode: http.StatusServiceUnavailable,
 			Message:    fmt.Sprintf("ошибка при подключении к Ollama: %v. Убедитесь, что Ollama запущена на %s", err, c.baseURL),
 			RetryAfter: 0,
 		}
 	}
-	defer resp.Body.Close()
 
 	// Проверяем статус ответа
 	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return "", &AIError{
+		return nil, &AIError{
 			StatusCode: resp.StatusCode,
 			Message:    fmt.Sprintf("Ollama вернула ошибку %d: %s", resp.StatusCode, string(bodyBytes)),
-			RetryAfter: 0,
+			RetryAfter: apperr.ParseRetryAfter(resp.Header.Get("Retry-After")),
 		}
 	}
 
-	// Читаем ответ
-	var ollamaResp OllamaResponse
-	if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
-		return "", fmt.Errorf("ошибка декодирования ответа от Ollama: %w", err)
-	}
-
-	if !ollamaResp.Done {
-		return "", fmt.Errorf("Ollama вернула неполный ответ")
-	}
-
-	return ollamaResp.Message.Content, nil
-}
-
-// getSystemPrompt возвращает системный промпт для анализа
-func getSystemPrompt() string {
-	return `
-Ты — 'TraceDebugger', элитный AI-аналитик, специализирующийся на поиске проблем в логах выполнения LLM-приложений. 
-
-**ВАЖНО: Отвечай ТОЛЬКО на русском языке!**
-
-Твоя задача — проанализировать предоставленный JSON-трейс из системы Langfuse и дать четкий, структурированный отчет **НА РУССКОМ ЯЗЫКЕ**.
-
-# Инструкции:
-1.  **Изучи общую информацию:** Обрати внимание на общую задержку ('latency') и стоимость ('totalCost') всего трейса.
-2.  **Проанализируй шаги ('observations'):** Внимательно изучи каждый шаг в массиве 'observations'.
-3.  **Выяви аномалии:** Найди одну из следующих проблем: 'ERROR' (ошибка), 'PERFORMANCE_BOTTLENECK' (узкое место производительности), 'HIGH_COST' (высокая стоимость), 'LOGICAL_LOOP' (логический цикл).
-4.  **Сформируй отчет НА РУССКОМ ЯЗЫКЕ:** Предоставь свой вывод в строго определенном JSON-формате. Не добавляй никаких комментариев или текста вне этого JSON.
-
-# Формат вывода (обязателен, все тексты на русском):
-{
-  "analysisSummary": {
-    "traceId": "ID_ТРЕЙСА",
-    "overallStatus": "HEALTHY | WARNING | ERROR",
-    "keyFinding": "Ключевой вывод в одном предложении на русском языке."
-  },
-  "detailedAnalysis": {
-    "anomalyType": "NONE | ERROR | PERFORMANCE_BOTTLENECK | HIGH_COST | LOGICAL_LOOP",
-    "description": "Подробное описание найденной проблемы на русском языке.",
-    "rootCause": "Твоя гипотеза о первопричине проблемы на русском языке.",
-    "recommendation": "Конкретный, действенный совет для разработчика на русском языке."
-  }
-}
-
-**Все поля description, rootCause, recommendation и keyFinding должны быть заполнены текстом на русском языке!**
-`
+	return resp, nil
 }
 
 // extractRetryAfter пытается найти retry время в сообщении об ошибке
@@ -0,0 +1,124 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"langfuse-analyzer-backend/apperr"
+)
+
+// streamServer - OpenAI-совместимый сервер, который отвечает потоком из
+// двух фрагментов текста и, если клиент попросил include_usage,
+// фрагментом с расходом токенов. Тело запроса сохраняется в body.
+func streamServer(t *testing.T, body *map[string]any) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, token := range []string{`{"a"`, `: 1}`} {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", token)
+		}
+		if options, ok := (*body)["stream_options"].(map[string]any); ok && options["include_usage"] == true {
+			fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":120,\"completion_tokens\":7,\"total_tokens\":127}}\n\n")
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestOpenAIStreamUsage(t *testing.T) {
+	tests := []struct {
+		name  string
+		caps  Capabilities
+		usage Usage
+	}{
+		{"include_usage requested", Capabilities{StreamUsage: true}, Usage{PromptTokens: 120, CompletionTokens: 7}},
+		{"server without stream_options", Capabilities{}, Usage{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body map[string]any
+			server := streamServer(t, &body)
+			client := NewOpenAICompatibleClient("", server.URL, "test-model", 100, nil, tt.caps)
+
+			ctx, usage := WithUsage(context.Background())
+			content, err := client.AnalyzeTraceStream(ctx, testRequest("ru"), func(string) {})
+			if err != nil {
+				t.Fatalf("AnalyzeTraceStream() error = %v", err)
+			}
+			if content != `{"a": 1}` {
+				t.Errorf("content = %q, want the joined tokens", content)
+			}
+			if _, sent := body["stream_options"]; sent != tt.caps.StreamUsage {
+				t.Errorf("stream_options sent = %t, want %t", sent, tt.caps.StreamUsage)
+			}
+			if *usage != tt.usage {
+				t.Errorf("usage = %+v, want %+v", *usage, tt.usage)
+			}
+		})
+	}
+}
+
+func TestProviderConnectionErrors(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	providers := []struct {
+		name   string
+		client func(baseURL string) AIClient
+	}{
+		{"anthropic", func(baseURL string) AIClient {
+			return NewAnthropicClient("key", baseURL, "", 100, "", timeout)
+		}},
+		{"gemini", func(baseURL string) AIClient {
+			return NewGeminiClient("key", baseURL, "", 100, timeout)
+		}},
+	}
+
+	// Сервер отвечает дольше таймаута клиента
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(slow.Close)
+	t.Cleanup(func() { close(release) })
+	// Адрес, на котором никто не слушает
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	for _, p := range providers {
+		tests := []struct {
+			name    string
+			baseURL string
+			status  int
+			code    apperr.Code
+		}{
+			{"client timeout", slow.URL, http.StatusGatewayTimeout, apperr.CodeTimeout},
+			{"connection refused", closed.URL, http.StatusServiceUnavailable, apperr.CodeUpstreamUnavailable},
+		}
+		for _, tt := range tests {
+			t.Run(p.name+"/"+tt.name, func(t *testing.T) {
+				_, err := p.client(tt.baseURL).AnalyzeTrace(context.Background(), testRequest("ru"))
+
+				var aiErr *AIError
+				if !errors.As(err, &aiErr) || aiErr.StatusCode != tt.status {
+					t.Fatalf("error = %v, want AIError with status %d", err, tt.status)
+				}
+				if code := apperr.From(err).Code; code != tt.code {
+					t.Errorf("code = %s, want %s", code, tt.code)
+				}
+				if !shouldFailover(context.Background(), err) {
+					t.Error("want failover to the next provider")
+				}
+			})
+		}
+	}
+}
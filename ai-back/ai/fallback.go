@@ -0,0 +1,167 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// FallbackProvider - звено цепочки провайдеров
+type FallbackProvider struct {
+	Provider ProviderType
+	Model    string
+	Client   AIClient
+}
+
+// Name - имя звена в логах и в ответе: "provider/model"
+func (p FallbackProvider) Name() string {
+	if p.Model == "" {
+		return string(p.Provider)
+	}
+	return fmt.Sprintf("%s/%s", p.Provider, p.Model)
+}
+
+// FallbackClient - AIClient поверх упорядоченного списка провайдеров.
+// Запрос уходит первому провайдеру; если тот недоступен (429, 5xx,
+// таймаут), запрос повторяется у следующего. Ошибки в данных запроса
+// (4xx) и отмена контекста запроса не приводят к переключению.
+type FallbackClient struct {
+	providers []FallbackProvider
+}
+
+// NewFallbackClient создает цепочку; providers должен быть не пустым
+func NewFallbackClient(providers []FallbackProvider) *FallbackClient {
+	return &FallbackClient{providers: providers}
+}
+
+// Providers возвращает звенья цепочки в порядке обращения
+func (c *FallbackClient) Providers() []FallbackProvider {
+	return c.providers
+}
+
+// Close закрывает клиентов всех провайдеров цепочки
+func (c *FallbackClient) Close() error {
+	var errs []error
+	for _, p := range c.providers {
+		if err := Close(p.Client); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// AnalyzeTrace - анализ у первого доступного провайдера цепочки
+func (c *FallbackClient) AnalyzeTrace(ctx context.Context, req *AnalysisRequest) (string, error) {
+	return c.try(ctx, func(client AIClient) (string, error) {
+		return client.AnalyzeTrace(ctx, req)
+	}, nil)
+}
+
+// AnalyzeTraceStream - потоковый анализ у первого доступного провайдера.
+// Переключение возможно, только пока клиенту не отправлено ни одного
+// токена: иначе ответы разных моделей смешались бы в потоке.
+func (c *FallbackClient) AnalyzeTraceStream(ctx context.Context, req *AnalysisRequest, onToken func(string)) (string, error) {
+	streamed := false
+	return c.try(ctx, func(client AIClient) (string, error) {
+		return client.AnalyzeTraceStream(ctx, req, func(token string) {
+			streamed = true
+			onToken(token)
+		})
+	}, func() bool { return streamed })
+}
+
+// try вызывает call для провайдеров по очереди, пока один не ответит или
+// ошибка не окажется такой, при которой переключаться бессмысленно
+func (c *FallbackClient) try(ctx context.Context, call func(AIClient) (string, error), streamed func() bool) (string, error) {
+	var lastErr error
+	for i, p := range c.providers {
+		content, err := call(p.Client)
+		if err == nil {
+			if i > 0 {
+				log.Printf("🔀 Ответил резервный провайдер %s", p.Name())
+			}
+			recordAnswer(ctx, p.Name())
+			return content, nil
+		}
+		lastErr = err
+
+		if i == len(c.providers)-1 || !shouldFailover(ctx, err) || (streamed != nil && streamed()) {
+			break
+		}
+		log.Printf("⚠️ Провайдер %s недоступен: %v. Пробуем %s", p.Name(), err, c.providers[i+1].Name())
+	}
+	return "", lastErr
+}
+
+// shouldFailover решает, имеет ли смысл повторить запрос у другого
+// провайдера: да - при лимите запросов, ошибке сервера и таймауте
+func shouldFailover(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var aiErr *AIError
+	if errors.As(err, &aiErr) && aiErr.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	return isUnavailable(err)
+}
+
+// isUnavailable - провайдер не смог обработать запрос: ошибка сервера,
+// таймаут или нет соединения (клиенты отдают его как 503)
+func isUnavailable(err error) bool {
+	var aiErr *AIError
+	if errors.As(err, &aiErr) {
+		return aiErr.StatusCode == http.StatusRequestTimeout || aiErr.StatusCode >= 500
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// answered - провайдеры, ответившие в рамках одного анализа. Части
+// большого трейса анализируются параллельно, поэтому нужен мьютекс.
+type answered struct {
+	mu        sync.Mutex
+	providers []string
+}
+
+type answeredKey struct{}
+
+// WithAnswered возвращает контекст, в котором FallbackClient запоминает,
+// какие провайдеры ответили. Прочитать их можно через AnsweredBy.
+func WithAnswered(ctx context.Context) context.Context {
+	return context.WithValue(ctx, answeredKey{}, &answered{})
+}
+
+// AnsweredBy возвращает имена ответивших провайдеров в порядке первого
+// ответа, без повторов; nil, если контекст создан не через WithAnswered
+func AnsweredBy(ctx context.Context) []string {
+	a, ok := ctx.Value(answeredKey{}).(*answered)
+	if !ok {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string(nil), a.providers...)
+}
+
+func recordAnswer(ctx context.Context, name string) {
+	a, ok := ctx.Value(answeredKey{}).(*answered)
+	if !ok {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, p := range a.providers {
+		if p == name {
+			return
+		}
+	}
+	a.providers = append(a.providers, name)
+}
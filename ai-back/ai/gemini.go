@@ -0,0 +1,400 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// GeminiClient - клиент для Google Gemini API (generateContent)
+type GeminiClient struct {
+	apiKey    string
+	baseURL   string
+	model     string
+	maxTokens int
+	client    *http.Client
+}
+
+// NewGeminiClient создает нового клиента для Gemini
+func NewGeminiClient(apiKey, baseURL, model string, maxTokens int, timeout time.Duration) *GeminiClient {
+	if baseURL == "" {
+		baseURL = "https://generativelanguage.googleapis.com"
+	}
+	if model == "" {
+		model = "gemini-2.0-flash"
+	}
+	if maxTokens <= 0 {
+		maxTokens = 1000
+	}
+	if timeout <= 0 {
+		timeout = 120 * time.Second
+	}
+
+	return &GeminiClient{
+		apiKey:    apiKey,
+		baseURL:   strings.TrimRight(baseURL, "/"),
+		model:     model,
+		maxTokens: maxTokens,
+		client: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+// GeminiRequest - тело запроса generateContent
+type GeminiRequest struct {
+	SystemInstruction *GeminiContent         `json:"systemInstruction,omitempty"`
+	Contents          []GeminiContent        `json:"contents"`
+	GenerationConfig  GeminiGenerationConfig `json:"generationConfig"`
+}
+
+// GeminiContent - сообщение диалога
+type GeminiContent struct {
+	Role  string       `json:"role,omitempty"` // "user" или "model"
+	Parts []GeminiPart `json:"parts"`
+}
+
+// GeminiPart - фрагмент сообщения
+type GeminiPart struct {
+	Text string `json:"text"`
+}
+
+// GeminiGenerationConfig - параметры генерации
+type GeminiGenerationConfig struct {
+	MaxOutputTokens  int                    `json:"maxOutputTokens,omitempty"`
+	ResponseMimeType string                 `json:"responseMimeType,omitempty"`
+	ResponseSchema   map[string]interface{} `json:"responseSchema,omitempty"`
+}
+
+// GeminiResponse - ответ generateContent (и каждое событие потока)
+type GeminiResponse struct {
+	Candidates []struct {
+		Content      GeminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	PromptFeedback *struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	// Расход токенов; в потоке - нарастающий итог
+	UsageMetadata *struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+// recordUsage записывает расход токенов из ответа
+func (r *GeminiResponse) recordUsage(ctx context.Context) {
+	if r.UsageMetadata != nil {
+		recordUsage(ctx, r.UsageMetadata.PromptTokenCount, r.UsageMetadata.CandidatesTokenCount)
+	}
+}
+
+// text возвращает текст первого кандидата
+func (r *GeminiResponse) text() string {
+	if len(r.Candidates) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	for _, part := range r.Candidates[0].Content.Parts {
+		sb.WriteString(part.Text)
+	}
+	return sb.String()
+}
+
+// blocked возвращает причину, по которой Gemini отказался отвечать
+func (r *GeminiResponse) blocked() string {
+	if r.PromptFeedback != nil && r.PromptFeedback.BlockReason != "" {
+		return r.PromptFeedback.BlockReason
+	}
+	if len(r.Candidates) > 0 {
+		switch reason := r.Candidates[0].FinishReason; reason {
+		case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT":
+			return reason
+		}
+	}
+	return ""
+}
+
+// geminiError - тело ошибки Google API
+type geminiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+	Details []struct {
+		Type       string `json:"@type"`
+		RetryDelay string `json:"retryDelay"`
+	} `json:"details"`
+}
+
+// contentRequest формирует запрос: системные сообщения переносятся в
+// systemInstruction, роль assistant называется model
+func (c *GeminiClient) contentRequest(analysisReq *AnalysisRequest) (*GeminiRequest, error) {
+	messages, err := buildMessages(analysisReq)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &GeminiRequest{
+		GenerationConfig: GeminiGenerationConfig{
+			MaxOutputTokens:  c.maxTokens,
+			ResponseMimeType: "application/json",
+			ResponseSchema:   geminiSchema(analysisReq.Session != nil),
+		},
+	}
+	var system []GeminiPart
+	for _, m := range messages {
+		switch m.Role {
+		case "system":
+			system = append(system, GeminiPart{Text: m.Content})
+		case "assistant":
+			req.Contents = append(req.Contents, GeminiContent{Role: "model", Parts: []GeminiPart{{Text: m.Content}}})
+		default:
+			req.Contents = append(req.Contents, GeminiContent{Role: "user", Parts: []GeminiPart{{Text: m.Content}}})
+		}
+	}
+	if len(system) > 0 {
+		req.SystemInstruction = &GeminiContent{Parts: system}
+	}
+	return req, nil
+}
+
+// Close закрывает простаивающие соединения клиента
+func (c *GeminiClient) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+// AnalyzeTrace - анализ трейса через Gemini
+func (c *GeminiClient) AnalyzeTrace(ctx context.Context, analysisReq *AnalysisRequest) (string, error) {
+	req, err := c.contentRequest(analysisReq)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.send(ctx, "generateContent", nil, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var geminiResp GeminiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&geminiResp); err != nil {
+		return "", fmt.Errorf("ошибка декодирования ответа от Gemini: %w", err)
+	}
+	geminiResp.recordUsage(ctx)
+	if reason := geminiResp.blocked(); reason != "" {
+		return "", fmt.Errorf("Gemini отказался отвечать: %s", reason)
+	}
+
+	content := geminiResp.text()
+	if content == "" {
+		return "", fmt.Errorf("нет ответа от AI")
+	}
+	return content, nil
+}
+
+// AnalyzeTraceStream - потоковый анализ трейса через Gemini. С alt=sse
+// каждое событие потока - отдельный GeminiResponse с очередным фрагментом.
+func (c *GeminiClient) AnalyzeTraceStream(ctx context.Context, analysisReq *AnalysisRequest, onToken func(string)) (string, error) {
+	req, err := c.contentRequest(analysisReq)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.send(ctx, "streamGenerateContent", url.Values{"alt": {"sse"}}, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var content strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		var chunk GeminiResponse
+		if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &chunk); err != nil {
+			return "", fmt.Errorf("ошибка декодирования потока от Gemini: %w", err)
+		}
+		chunk.recordUsage(ctx)
+		if reason := chunk.blocked(); reason != "" {
+			return "", fmt.Errorf("Gemini отказался отвечать: %s", reason)
+		}
+		if delta := chunk.text(); delta != "" {
+			content.WriteString(delta)
+			onToken(delta)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("ошибка чтения потока от Gemini: %w", err)
+	}
+
+	if content.Len() == 0 {
+		return "", fmt.Errorf("нет ответа от AI")
+	}
+	return content.String(), nil
+}
+
+// send отправляет запрос к models/{model}:{method} и возвращает ответ с проверенным статусом
+func (c *GeminiClient) send(ctx context.Context, method string, query url.Values, body *GeminiRequest) (*http.Response, error) {
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при маршалинге запроса к Gemini: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/v1beta/models/%s:%s", c.baseURL, url.PathEscape(c.model), method)
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания запроса к Gemini: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-goog-api-key", c.apiKey)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, connectionError("Gemini", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, geminiAIError(resp.StatusCode, bodyBytes)
+	}
+
+	return resp, nil
+}
+
+// geminiAIError превращает ошибку Google API в AIError. RESOURCE_EXHAUSTED
+// (квота или rate limit) - это 429; задержка берется из RetryInfo в details.
+func geminiAIError(status int, body []byte) *AIError {
+	var errBody struct {
+		Error *geminiError `json:"error"`
+	}
+	if json.Unmarshal(body, &errBody) != nil || errBody.Error == nil {
+		return &AIError{
+			StatusCode: status,
+			Message:    fmt.Sprintf("Gemini вернул ошибку %d: %s", status, string(body)),
+		}
+	}
+	apiErr := errBody.Error
+
+	aiErr := &AIError{
+		StatusCode: status,
+		Message:    fmt.Sprintf("Gemini вернул ошибку %d %s: %s", status, apiErr.Status, apiErr.Message),
+	}
+	switch apiErr.Status {
+	case "RESOURCE_EXHAUSTED":
+		aiErr.StatusCode = http.StatusTooManyRequests
+	case "UNAVAILABLE":
+		aiErr.StatusCode = http.StatusServiceUnavailable
+	}
+
+	for _, d := range apiErr.Details {
+		if !strings.HasSuffix(d.Type, "google.rpc.RetryInfo") || d.RetryDelay == "" {
+			continue
+		}
+		if delay, err := time.ParseDuration(d.RetryDelay); err == nil {
+			aiErr.RetryAfter = int(math.Ceil(delay.Seconds()))
+		}
+	}
+	if aiErr.StatusCode == http.StatusTooManyRequests && aiErr.RetryAfter == 0 {
+		if seconds := extractRetryAfter(apiErr.Message); seconds > 0 {
+			aiErr.RetryAfter = seconds
+		} else {
+			aiErr.RetryAfter = 10
+		}
+	}
+	return aiErr
+}
+
+// geminiSchema возвращает responseSchema отчета (подмножество OpenAPI,
+// которое понимает Gemini). Перечисления те же, по которым проверяется ответ.
+func geminiSchema(session bool) map[string]interface{} {
+	str := map[string]interface{}{"type": "STRING"}
+	strArray := map[string]interface{}{"type": "ARRAY", "items": str}
+	enum := func(values []string) map[string]interface{} {
+		return map[string]interface{}{"type": "STRING", "enum": values}
+	}
+	object := func(properties map[string]interface{}, required ...string) map[string]interface{} {
+		schema := map[string]interface{}{"type": "OBJECT", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+
+	anomalies := traceAnomalies
+	if session {
+		anomalies = sessionAnomalies
+	}
+
+	summary := map[string]interface{}{
+		"overallStatus": enum(enumStrings(overallStatuses)),
+		"keyFinding":    str,
+	}
+	if session {
+		summary["sessionId"] = str
+	} else {
+		summary["traceId"] = str
+	}
+
+	finding := map[string]interface{}{
+		"type":             enum(enumStrings(anomalies[1:])),
+		"severity":         enum(enumStrings(severities)),
+		"observationIds":   strArray,
+		"observationNames": strArray,
+		"evidence": object(map[string]interface{}{
+			"latency":      map[string]interface{}{"type": "NUMBER"},
+			"cost":         map[string]interface{}{"type": "NUMBER"},
+			"tokens":       map[string]interface{}{"type": "INTEGER"},
+			"errorMessage": str,
+		}),
+		"description":    str,
+		"recommendation": str,
+	}
+	if session {
+		finding["traceIds"] = strArray
+	}
+
+	properties := map[string]interface{}{
+		"analysisSummary": object(summary, "overallStatus", "keyFinding"),
+		"detailedAnalysis": object(map[string]interface{}{
+			"anomalyType":    enum(enumStrings(anomalies)),
+			"description":    str,
+			"rootCause":      str,
+			"recommendation": str,
+		}, "anomalyType", "description", "recommendation"),
+		"findings": map[string]interface{}{
+			"type":  "ARRAY",
+			"items": object(finding, "type", "severity", "description", "recommendation"),
+		},
+	}
+	required := []string{"analysisSummary", "detailedAnalysis", "findings"}
+	if session {
+		properties["traceReferences"] = map[string]interface{}{
+			"type": "ARRAY",
+			"items": object(map[string]interface{}{
+				"traceId": str,
+				"turn":    map[string]interface{}{"type": "INTEGER"},
+				"status":  enum(enumStrings(overallStatuses)),
+				"note":    str,
+			}, "traceId"),
+		}
+	}
+	return object(properties, required...)
+}
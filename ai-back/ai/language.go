@@ -0,0 +1,165 @@
+package ai
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// ErrUnsupportedLanguage - запрошен язык отчета, которого нет в списке поддерживаемых
+var ErrUnsupportedLanguage = errors.New("язык отчета не поддерживается")
+
+// DefaultLanguage - язык отчета, если он не задан ни в запросе, ни в конфигурации
+const DefaultLanguage = "ru"
+
+// fallbackLanguage - язык шаблонов для языков без собственного набора промптов.
+// Шаблоны этого набора подставляют название языка через {{.LanguageName}}.
+const fallbackLanguage = "en"
+
+// languageInfo описывает язык отчета
+type languageInfo struct {
+	name   string                // название для промпта, на английском
+	script []*unicode.RangeTable // письменность, которой пишут на этом языке
+}
+
+var (
+	latin    = []*unicode.RangeTable{unicode.Latin}
+	cyrillic = []*unicode.RangeTable{unicode.Cyrillic}
+)
+
+// languages - поддерживаемые языки отчета по кодам ISO 639-1
+var languages = map[string]languageInfo{
+	"ru": {"Russian", cyrillic},
+	"uk": {"Ukrainian", cyrillic},
+	"en": {"English", latin},
+	"de": {"German", latin},
+	"fr": {"French", latin},
+	"es": {"Spanish", latin},
+	"it": {"Italian", latin},
+	"pt": {"Portuguese", latin},
+	"pl": {"Polish", latin},
+	"tr": {"Turkish", latin},
+	"zh": {"Chinese", []*unicode.RangeTable{unicode.Han}},
+	"ja": {"Japanese", []*unicode.RangeTable{unicode.Hiragana, unicode.Katakana, unicode.Han}},
+	"ko": {"Korean", []*unicode.RangeTable{unicode.Hangul}},
+}
+
+// NormalizeLanguage приводит код языка к виду "en": убирает регион ("en-US")
+// и регистр. Пустая строка возвращается как есть.
+func NormalizeLanguage(code string) (string, error) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if i := strings.IndexAny(code, "-_"); i >= 0 {
+		code = code[:i]
+	}
+	if code == "" {
+		return "", nil
+	}
+	if _, ok := languages[code]; !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedLanguage, code)
+	}
+	return code, nil
+}
+
+// SupportedLanguages возвращает коды поддерживаемых языков в алфавитном порядке
+func SupportedLanguages() []string {
+	codes := make([]string, 0, len(languages))
+	for code := range languages {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+func languageName(code string) string {
+	if info, ok := languages[code]; ok {
+		return info.name
+	}
+	return code
+}
+
+// minLetters - поля короче этого числа букв не проверяются: по ним
+// нельзя надежно определить язык
+const minLetters = 12
+
+// checkLanguage проверяет, что текстовые поля отчета написаны письменностью
+// запрошенного языка. Языки с одной письменностью (например, en и de)
+// этой проверкой не различаются.
+func (r *AnalysisResult) checkLanguage(code string) []string {
+	info, ok := languages[code]
+	if !ok {
+		return nil
+	}
+
+	type field struct{ name, text string }
+	fields := []field{
+		{"analysisSummary.keyFinding", r.AnalysisSummary.KeyFinding},
+		{"detailedAnalysis.description", r.DetailedAnalysis.Description},
+		{"detailedAnalysis.rootCause", r.DetailedAnalysis.RootCause},
+		{"detailedAnalysis.recommendation", r.DetailedAnalysis.Recommendation},
+	}
+	for i, f := range r.Findings {
+		fields = append(fields,
+			field{fmt.Sprintf("findings[%d].description", i), f.Description},
+			field{fmt.Sprintf("findings[%d].recommendation", i), f.Recommendation},
+		)
+	}
+	for i, ref := range r.TraceReferences {
+		fields = append(fields, field{fmt.Sprintf("traceReferences[%d].note", i), ref.Note})
+	}
+
+	var problems []string
+	for _, f := range fields {
+		if !inScript(f.text, info.script) {
+			problems = append(problems, fmt.Sprintf("%s написано не на языке %s (%s)", f.name, code, info.name))
+		}
+	}
+	return problems
+}
+
+// inScript сообщает, что большая часть букв текста относится к письменности
+// script. Идентификаторы (имена наблюдений, значения перечислений, числа
+// с единицами) не учитываются: они пишутся латиницей на любом языке.
+func inScript(text string, script []*unicode.RangeTable) bool {
+	var total, matched int
+	for _, word := range strings.Fields(text) {
+		if isIdentifier(word) {
+			continue
+		}
+		for _, r := range word {
+			if !unicode.IsLetter(r) {
+				continue
+			}
+			total++
+			if unicode.In(r, script...) {
+				matched++
+			}
+		}
+	}
+	if total < minLetters {
+		return true
+	}
+	return matched*2 >= total
+}
+
+// isIdentifier отличает идентификаторы от слов естественного языка
+func isIdentifier(word string) bool {
+	word = strings.Trim(word, ".,;:!?()[]{}\"'«»`")
+	if word == "" {
+		return true
+	}
+	if strings.ContainsAny(word, "_/.=@#$") {
+		return true
+	}
+	hasUpper, hasLower := false, false
+	for _, r := range word {
+		if unicode.IsDigit(r) {
+			return true
+		}
+		hasUpper = hasUpper || unicode.IsUpper(r)
+		hasLower = hasLower || unicode.IsLower(r)
+	}
+	// LLM, API; у иероглифов регистра нет, они идентификаторами не считаются
+	return hasUpper && !hasLower
+}
@@ -0,0 +1,381 @@
+package ai
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"langfuse-analyzer-backend/heuristics"
+	"langfuse-analyzer-backend/langfuse"
+)
+
+// AnalysisRequest - данные для анализа. Заполняется либо Trace, либо Session.
+type AnalysisRequest struct {
+	Trace   *langfuse.Trace
+	Session *langfuse.Session
+	// Facts - находки эвристик, вычисленные по трейсу до обращения к модели.
+	// Передаются модели как проверенные факты.
+	Facts []heuristics.Finding
+	// Repair - предыдущий ответ модели, не прошедший проверку. Если задан,
+	// модель просят исправить этот ответ.
+	Repair *Repair
+	// Language - код языка отчета (см. NormalizeLanguage); если пуст,
+	// используется DefaultLanguage
+	Language string
+	// Chunk - если задан, Trace содержит только часть наблюдений трейса
+	// (этап map при анализе по частям)
+	Chunk *Chunk
+	// Partials - отчеты по частям трейса, которые нужно объединить в один
+	// (этап reduce). Trace при этом - полный трейс, по которому проверяются ссылки.
+	Partials []*AnalysisResult
+	// Prompts - шаблоны промптов; если nil, используются встроенные
+	Prompts *Prompts
+}
+
+// Chunk - номер части трейса при анализе по частям
+type Chunk struct {
+	Index int // с 1
+	Total int
+}
+
+// Repair - ответ модели, который нужно исправить, и найденные в нем проблемы
+type Repair struct {
+	PreviousResponse string
+	Problems         []string
+}
+
+// Message - сообщение диалога с моделью
+type Message struct {
+	Role    string // "system", "user" или "assistant"
+	Content string
+}
+
+//go:embed prompts/*/*.tmpl prompts/VERSION
+var embeddedPrompts embed.FS
+
+// Имена шаблонов (файлов в каталоге промптов)
+const (
+	tmplSystemTrace   = "system_trace.tmpl"
+	tmplSystemSession = "system_session.tmpl"
+	tmplUserTrace     = "user_trace.tmpl"
+	tmplUserSession   = "user_session.tmpl"
+	tmplSystemReduce  = "system_reduce.tmpl"
+	tmplUserReduce    = "user_reduce.tmpl"
+	tmplRepair        = "repair.tmpl"
+)
+
+// Prompts - набор шаблонов промптов (text/template) с версией. Шаблоны
+// хранятся по языкам: каталог prompts/<код языка>/. Для языков без своего
+// каталога используются шаблоны fallbackLanguage.
+type Prompts struct {
+	version string
+	sets    map[string]*template.Template
+}
+
+// promptData - переменные, доступные в шаблонах
+type promptData struct {
+	Schema       string   // формат JSON-ответа
+	Language     string   // код языка ответа, например "ru"
+	LanguageName string   // название языка ответа на английском, например "Russian"
+	Data         string   // JSON трейса или сессии
+	Facts        string   // JSON находок эвристик, пусто если их нет
+	Chunk        *Chunk   // номер части трейса, nil если трейс целиком
+	Problems     []string // замечания к предыдущему ответу (repair.tmpl)
+}
+
+var defaultPrompts = mustLoadEmbeddedPrompts()
+
+func mustLoadEmbeddedPrompts() *Prompts {
+	p, err := LoadPrompts("")
+	if err != nil {
+		panic(fmt.Sprintf("ошибка загрузки встроенных промптов: %v", err))
+	}
+	return p
+}
+
+// DefaultPrompts возвращает встроенный набор промптов
+func DefaultPrompts() *Prompts {
+	return defaultPrompts
+}
+
+// LoadPrompts загружает шаблоны промптов. Сначала читаются встроенные
+// шаблоны, затем файлы <dir>/<язык>/*.tmpl (если dir не пуст) переопределяют
+// одноименные. Каталог нового языка дополняет встроенные шаблоны
+// fallbackLanguage. Версия берется из файла VERSION в dir; если его нет,
+// к встроенной версии добавляется хеш переопределенных шаблонов.
+func LoadPrompts(dir string) (*Prompts, error) {
+	sets := make(map[string]*template.Template)
+	entries, err := fs.ReadDir(embeddedPrompts, "prompts")
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения встроенных шаблонов: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		tmpl, err := template.ParseFS(embeddedPrompts, "prompts/"+entry.Name()+"/*.tmpl")
+		if err != nil {
+			return nil, fmt.Errorf("ошибка разбора встроенных шаблонов %s: %w", entry.Name(), err)
+		}
+		sets[entry.Name()] = tmpl
+	}
+	if sets[fallbackLanguage] == nil {
+		return nil, fmt.Errorf("нет встроенных шаблонов для языка %s", fallbackLanguage)
+	}
+
+	versionBytes, err := fs.ReadFile(embeddedPrompts, "prompts/VERSION")
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения версии встроенных шаблонов: %w", err)
+	}
+	version := strings.TrimSpace(string(versionBytes))
+
+	if dir == "" {
+		return &Prompts{version: version, sets: sets}, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*", "*.tmpl"))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка поиска шаблонов в %s: %w", dir, err)
+	}
+	// Сначала переопределения существующих языков, чтобы новые языки
+	// копировали уже переопределенные шаблоны fallbackLanguage
+	sort.SliceStable(files, func(i, j int) bool {
+		iNew := sets[filepath.Base(filepath.Dir(files[i]))] == nil
+		jNew := sets[filepath.Base(filepath.Dir(files[j]))] == nil
+		if iNew != jNew {
+			return jNew
+		}
+		return files[i] < files[j]
+	})
+
+	hash := sha256.New()
+	for _, file := range files {
+		lang := filepath.Base(filepath.Dir(file))
+		tmpl := sets[lang]
+		if tmpl == nil {
+			if tmpl, err = sets[fallbackLanguage].Clone(); err != nil {
+				return nil, fmt.Errorf("ошибка копирования шаблонов для %s: %w", lang, err)
+			}
+			sets[lang] = tmpl
+		}
+
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения шаблона %s: %w", file, err)
+		}
+		if _, err := tmpl.New(filepath.Base(file)).Parse(string(content)); err != nil {
+			return nil, fmt.Errorf("ошибка разбора шаблона %s: %w", file, err)
+		}
+		hash.Write([]byte(lang + "/" + filepath.Base(file)))
+		hash.Write(content)
+	}
+
+	if custom, err := os.ReadFile(filepath.Join(dir, "VERSION")); err == nil {
+		version = strings.TrimSpace(string(custom))
+	} else if len(files) > 0 {
+		version += "+custom." + hex.EncodeToString(hash.Sum(nil))[:8]
+	}
+
+	return &Prompts{version: version, sets: sets}, nil
+}
+
+// Version возвращает идентификатор версии набора промптов
+func (p *Prompts) Version() string {
+	return p.version
+}
+
+func (p *Prompts) render(name string, data promptData) (string, error) {
+	tmpl := p.sets[data.Language]
+	if tmpl == nil {
+		tmpl = p.sets[fallbackLanguage]
+	}
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		return "", fmt.Errorf("ошибка шаблона %s: %w", name, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// buildMessages формирует сообщения для запроса к модели: системный промпт,
+// данные для анализа и, при исправлении, предыдущий ответ с замечаниями
+func buildMessages(req *AnalysisRequest) ([]Message, error) {
+	prompts := req.Prompts
+	if prompts == nil {
+		prompts = defaultPrompts
+	}
+
+	language := req.Language
+	if language == "" {
+		language = DefaultLanguage
+	}
+	data := promptData{Language: language, LanguageName: languageName(language), Chunk: req.Chunk}
+	systemName, userName := tmplSystemTrace, tmplUserTrace
+
+	switch {
+	case req.Trace != nil && len(req.Partials) > 0:
+		reduceStr, err := json.Marshal(newReduceInput(req.Trace, req.Partials))
+		if err != nil {
+			return nil, fmt.Errorf("ошибка при маршалинге отчетов по частям: %w", err)
+		}
+		data.Data = string(reduceStr)
+		data.Schema = traceSchema()
+		systemName, userName = tmplSystemReduce, tmplUserReduce
+	case req.Session != nil:
+		sessionStr, err := json.Marshal(req.Session)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка при маршалинге сессии: %w", err)
+		}
+		data.Data = string(sessionStr)
+		data.Schema = sessionSchema()
+		systemName, userName = tmplSystemSession, tmplUserSession
+	case req.Trace != nil:
+		traceStr, err := json.Marshal(req.Trace)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка при маршалинге трейса: %w", err)
+		}
+		data.Data = string(traceStr)
+		data.Schema = traceSchema()
+	default:
+		return nil, fmt.Errorf("не указан трейс для анализа")
+	}
+
+	if len(req.Facts) > 0 {
+		factsStr, err := json.Marshal(req.Facts)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка при маршалинге фактов: %w", err)
+		}
+		data.Facts = string(factsStr)
+	}
+
+	systemPrompt, err := prompts.render(systemName, data)
+	if err != nil {
+		return nil, err
+	}
+	userPrompt, err := prompts.render(userName, data)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []Message{
+		{Role: "system", Content: systemPrompt},
+		{Role: "user", Content: userPrompt},
+	}
+	if req.Repair != nil {
+		data.Problems = req.Repair.Problems
+		repairPrompt, err := prompts.render(tmplRepair, data)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages,
+			Message{Role: "assistant", Content: req.Repair.PreviousResponse},
+			Message{Role: "user", Content: repairPrompt},
+		)
+	}
+	return messages, nil
+}
+
+// reduceInput - данные для объединения отчетов по частям трейса: сводка
+// трейса без наблюдений и отчеты по частям
+type reduceInput struct {
+	Trace    traceOverview     `json:"trace"`
+	Partials []*AnalysisResult `json:"partials"`
+}
+
+type traceOverview struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name,omitempty"`
+	Timestamp    time.Time `json:"timestamp"`
+	Latency      float64   `json:"latency"`   // секунды
+	TotalCost    float64   `json:"totalCost"` // USD
+	Observations int       `json:"observations"`
+	Errors       int       `json:"errors"`
+}
+
+func newReduceInput(trace *langfuse.Trace, partials []*AnalysisResult) reduceInput {
+	overview := traceOverview{
+		ID:           trace.ID,
+		Name:         trace.Name,
+		Timestamp:    trace.Timestamp,
+		Latency:      trace.Latency,
+		TotalCost:    trace.TotalCost,
+		Observations: len(trace.Observations),
+	}
+	for i := range trace.Observations {
+		if trace.Observations[i].IsError() {
+			overview.Errors++
+		}
+	}
+	return reduceInput{Trace: overview, Partials: partials}
+}
+
+// traceSchema возвращает формат отчета по трейсу. Строится из тех же
+// перечислений, по которым ParseAnalysisResult проверяет ответ.
+func traceSchema() string {
+	return fmt.Sprintf(`{
+  "analysisSummary": {
+    "traceId": "string",
+    "overallStatus": "%s",
+    "keyFinding": "string"
+  },
+  "detailedAnalysis": {
+    "anomalyType": "%s",
+    "description": "string",
+    "rootCause": "string",
+    "recommendation": "string"
+  },
+  "findings": [
+    {
+      "type": "%s",
+      "severity": "%s",
+      "observationIds": ["string"],
+      "observationNames": ["string"],
+      "evidence": {"latency": 0.0, "cost": 0.0, "tokens": 0, "errorMessage": "string"},
+      "description": "string",
+      "recommendation": "string"
+    }
+  ]
+}`, joinEnum(overallStatuses), joinEnum(traceAnomalies), joinEnum(traceAnomalies[1:]), joinEnum(severities))
+}
+
+// sessionSchema возвращает формат отчета по сессии
+func sessionSchema() string {
+	return fmt.Sprintf(`{
+  "analysisSummary": {
+    "sessionId": "string",
+    "overallStatus": "%s",
+    "keyFinding": "string"
+  },
+  "detailedAnalysis": {
+    "anomalyType": "%s",
+    "description": "string",
+    "rootCause": "string",
+    "recommendation": "string"
+  },
+  "traceReferences": [
+    {"traceId": "string", "turn": 1, "status": "%s", "note": "string"}
+  ],
+  "findings": [
+    {
+      "type": "%s",
+      "severity": "%s",
+      "traceIds": ["string"],
+      "observationIds": ["string"],
+      "observationNames": ["string"],
+      "evidence": {"latency": 0.0, "cost": 0.0, "tokens": 0, "errorMessage": "string"},
+      "description": "string",
+      "recommendation": "string"
+    }
+  ]
+}`, joinEnum(overallStatuses), joinEnum(sessionAnomalies), joinEnum(overallStatuses),
+		joinEnum(sessionAnomalies[1:]), joinEnum(severities))
+}
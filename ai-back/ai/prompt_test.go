@@ -0,0 +1,37 @@
+package ai
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRepairPromptLanguage(t *testing.T) {
+	tests := []struct {
+		language string
+		want     []string
+	}{
+		{"ru", []string{"не прошел автоматическую проверку", "Все текстовые поля должны быть на русском языке"}},
+		{"en", []string{"failed automatic validation", "All text fields must be in English"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.language, func(t *testing.T) {
+			req := testRequest(tt.language)
+			req.Repair = &Repair{PreviousResponse: "{}", Problems: []string{"findings пуст"}}
+
+			messages, err := buildMessages(req)
+			if err != nil {
+				t.Fatalf("buildMessages() error = %v", err)
+			}
+			repair := messages[len(messages)-1]
+			if repair.Role != "user" || messages[len(messages)-2].Content != "{}" {
+				t.Fatalf("messages = %+v, want the previous answer followed by the repair prompt", messages)
+			}
+			for _, want := range append(tt.want, "- findings пуст") {
+				if !strings.Contains(repair.Content, want) {
+					t.Errorf("repair prompt = %q, want it to contain %q", repair.Content, want)
+				}
+			}
+		})
+	}
+}
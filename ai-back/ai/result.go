@@ -0,0 +1,369 @@
+package ai
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"langfuse-analyzer-backend/apperr"
+	"langfuse-analyzer-backend/langfuse"
+)
+
+// OverallStatus - общий статус трейса в отчете
+type OverallStatus string
+
+const (
+	StatusHealthy OverallStatus = "HEALTHY"
+	StatusWarning OverallStatus = "WARNING"
+	StatusError   OverallStatus = "ERROR"
+)
+
+// AnomalyType - тип найденной аномалии
+type AnomalyType string
+
+const (
+	AnomalyNone                  AnomalyType = "NONE"
+	AnomalyError                 AnomalyType = "ERROR"
+	AnomalyPerformanceBottleneck AnomalyType = "PERFORMANCE_BOTTLENECK"
+	AnomalyHighCost              AnomalyType = "HIGH_COST"
+	AnomalyLogicalLoop           AnomalyType = "LOGICAL_LOOP"
+
+	// Аномалии, которые ищутся только при анализе сессии
+	AnomalyRepeatedFailure AnomalyType = "REPEATED_FAILURE"
+	AnomalyContextLoss     AnomalyType = "CONTEXT_LOSS"
+	AnomalyEscalatingCost  AnomalyType = "ESCALATING_COST"
+)
+
+// Severity - серьезность отдельной находки
+type Severity string
+
+const (
+	SeverityHigh   Severity = "HIGH"
+	SeverityMedium Severity = "MEDIUM"
+	SeverityLow    Severity = "LOW"
+)
+
+var (
+	overallStatuses = []OverallStatus{StatusHealthy, StatusWarning, StatusError}
+	severities      = []Severity{SeverityHigh, SeverityMedium, SeverityLow}
+	traceAnomalies  = []AnomalyType{
+		AnomalyNone, AnomalyError, AnomalyPerformanceBottleneck, AnomalyHighCost, AnomalyLogicalLoop,
+	}
+	sessionAnomalies = append(append([]AnomalyType(nil), traceAnomalies...),
+		AnomalyRepeatedFailure, AnomalyContextLoss, AnomalyEscalatingCost,
+	)
+)
+
+// AnalysisResult - отчет модели; повторяет формат из системного промпта
+type AnalysisResult struct {
+	AnalysisSummary  AnalysisSummary  `json:"analysisSummary"`
+	DetailedAnalysis DetailedAnalysis `json:"detailedAnalysis"`
+	TraceReferences  []TraceReference `json:"traceReferences,omitempty"` // только для сессий
+	Findings         []Finding        `json:"findings"`
+}
+
+// AnalysisSummary - краткий итог анализа
+type AnalysisSummary struct {
+	TraceID       string        `json:"traceId,omitempty"`
+	SessionID     string        `json:"sessionId,omitempty"`
+	OverallStatus OverallStatus `json:"overallStatus"`
+	KeyFinding    string        `json:"keyFinding"`
+}
+
+// DetailedAnalysis - подробное описание основной проблемы
+type DetailedAnalysis struct {
+	AnomalyType    AnomalyType `json:"anomalyType"`
+	Description    string      `json:"description"`
+	RootCause      string      `json:"rootCause"`
+	Recommendation string      `json:"recommendation"`
+}
+
+// TraceReference - ссылка на ход сессии, имеющий отношение к выводу
+type TraceReference struct {
+	TraceID string        `json:"traceId"`
+	Turn    int           `json:"turn"`
+	Status  OverallStatus `json:"status"`
+	Note    string        `json:"note"`
+}
+
+// Finding - отдельная проблема, найденная моделью, с привязкой к наблюдениям
+type Finding struct {
+	Type             AnomalyType `json:"type"`
+	Severity         Severity    `json:"severity"`
+	TraceIDs         []string    `json:"traceIds,omitempty"` // только для сессий
+	ObservationIDs   []string    `json:"observationIds"`
+	ObservationNames []string    `json:"observationNames,omitempty"`
+	Evidence         Evidence    `json:"evidence"`
+	Description      string      `json:"description"`
+	Recommendation   string      `json:"recommendation"`
+}
+
+// Evidence - значения, на которых модель основывает находку
+type Evidence struct {
+	Latency      float64 `json:"latency,omitempty"` // секунды
+	Cost         float64 `json:"cost,omitempty"`    // USD
+	Tokens       int     `json:"tokens,omitempty"`
+	ErrorMessage string  `json:"errorMessage,omitempty"`
+}
+
+// ValidationError - ответ модели не соответствует ожидаемому формату
+type ValidationError struct {
+	Problems []string
+	Raw      string // исходный ответ модели
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("ответ модели не прошел проверку: %s", strings.Join(e.Problems, "; "))
+}
+
+// AppError - ошибка API: модель так и не вернула отчет в нужном формате
+func (e *ValidationError) AppError() *apperr.Error {
+	return &apperr.Error{
+		Code:    apperr.CodeInvalidModelOutput,
+		Message: "AI вернул ответ в неверном формате. Попробуйте повторить анализ.",
+		Details: map[string]any{"problems": e.Problems},
+		Err:     e,
+	}
+}
+
+// ParseAnalysisResult извлекает JSON из ответа модели (в том числе из
+// markdown-блока или окружающего текста), разбирает и проверяет его
+// относительно данных запроса.
+func ParseAnalysisResult(raw string, req *AnalysisRequest) (*AnalysisResult, error) {
+	jsonStr, ok := ExtractJSON(raw)
+	if !ok {
+		return nil, &ValidationError{Problems: []string{"ответ не содержит JSON-объекта"}, Raw: raw}
+	}
+
+	var result AnalysisResult
+	if err := json.Unmarshal([]byte(jsonStr), &result); err != nil {
+		return nil, &ValidationError{Problems: []string{"некорректный JSON: " + err.Error()}, Raw: raw}
+	}
+
+	result.normalize()
+	if problems := result.Validate(req); len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems, Raw: raw}
+	}
+	return &result, nil
+}
+
+// normalize приводит значения перечислений к каноническому виду
+func (r *AnalysisResult) normalize() {
+	r.AnalysisSummary.OverallStatus = OverallStatus(normalizeEnum(string(r.AnalysisSummary.OverallStatus)))
+	r.DetailedAnalysis.AnomalyType = AnomalyType(normalizeEnum(string(r.DetailedAnalysis.AnomalyType)))
+	for i := range r.TraceReferences {
+		r.TraceReferences[i].Status = OverallStatus(normalizeEnum(string(r.TraceReferences[i].Status)))
+	}
+	for i := range r.Findings {
+		r.Findings[i].Type = AnomalyType(normalizeEnum(string(r.Findings[i].Type)))
+		r.Findings[i].Severity = Severity(normalizeEnum(string(r.Findings[i].Severity)))
+	}
+	if r.Findings == nil {
+		r.Findings = []Finding{}
+	}
+}
+
+func normalizeEnum(value string) string {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	return strings.NewReplacer(" ", "_", "-", "_").Replace(value)
+}
+
+// Validate возвращает список нарушений формата; пустой список - отчет корректен.
+// Ссылки на трейсы и наблюдения проверяются по данным запроса.
+func (r *AnalysisResult) Validate(req *AnalysisRequest) []string {
+	var problems []string
+	session := req.Session != nil
+
+	if !containsEnum(overallStatuses, r.AnalysisSummary.OverallStatus) {
+		problems = append(problems, fmt.Sprintf("analysisSummary.overallStatus = %q, допустимо: %s",
+			r.AnalysisSummary.OverallStatus, joinEnum(overallStatuses)))
+	}
+	if strings.TrimSpace(r.AnalysisSummary.KeyFinding) == "" {
+		problems = append(problems, "analysisSummary.keyFinding не заполнено")
+	}
+
+	anomalies := traceAnomalies
+	if session {
+		anomalies = sessionAnomalies
+	}
+	if !containsEnum(anomalies, r.DetailedAnalysis.AnomalyType) {
+		problems = append(problems, fmt.Sprintf("detailedAnalysis.anomalyType = %q, допустимо: %s",
+			r.DetailedAnalysis.AnomalyType, joinEnum(anomalies)))
+	}
+	if strings.TrimSpace(r.DetailedAnalysis.Description) == "" {
+		problems = append(problems, "detailedAnalysis.description не заполнено")
+	}
+	if strings.TrimSpace(r.DetailedAnalysis.Recommendation) == "" {
+		problems = append(problems, "detailedAnalysis.recommendation не заполнено")
+	}
+	if r.DetailedAnalysis.AnomalyType != AnomalyNone && strings.TrimSpace(r.DetailedAnalysis.RootCause) == "" {
+		problems = append(problems, "detailedAnalysis.rootCause не заполнено")
+	}
+
+	if r.DetailedAnalysis.AnomalyType != AnomalyNone && len(r.Findings) == 0 {
+		problems = append(problems, "findings пуст, хотя anomalyType не NONE")
+	}
+
+	knownTraces, knownObservations := knownIDs(req)
+	for i, f := range r.Findings {
+		if f.Type == AnomalyNone || !containsEnum(anomalies, f.Type) {
+			problems = append(problems, fmt.Sprintf("findings[%d].type = %q, допустимо: %s",
+				i, f.Type, joinEnum(anomalies[1:])))
+		}
+		if !containsEnum(severities, f.Severity) {
+			problems = append(problems, fmt.Sprintf("findings[%d].severity = %q, допустимо: %s",
+				i, f.Severity, joinEnum(severities)))
+		}
+		if strings.TrimSpace(f.Description) == "" {
+			problems = append(problems, fmt.Sprintf("findings[%d].description не заполнено", i))
+		}
+		if strings.TrimSpace(f.Recommendation) == "" {
+			problems = append(problems, fmt.Sprintf("findings[%d].recommendation не заполнено", i))
+		}
+		for _, id := range f.ObservationIDs {
+			if !knownObservations[id] {
+				problems = append(problems, fmt.Sprintf("findings[%d].observationIds: наблюдения %q нет в трейсе", i, id))
+			}
+		}
+		for _, id := range f.TraceIDs {
+			if !knownTraces[id] {
+				problems = append(problems, fmt.Sprintf("findings[%d].traceIds: трейса %q нет в сессии", i, id))
+			}
+		}
+	}
+
+	if session {
+		for i, ref := range r.TraceReferences {
+			if ref.TraceID == "" {
+				problems = append(problems, fmt.Sprintf("traceReferences[%d].traceId не заполнено", i))
+			}
+			if ref.Status != "" && !containsEnum(overallStatuses, ref.Status) {
+				problems = append(problems, fmt.Sprintf("traceReferences[%d].status = %q, допустимо: %s",
+					i, ref.Status, joinEnum(overallStatuses)))
+			}
+		}
+	}
+
+	if req.Language != "" {
+		problems = append(problems, r.checkLanguage(req.Language)...)
+	}
+
+	return problems
+}
+
+// knownIDs возвращает множества ID трейсов и наблюдений из данных запроса
+func knownIDs(req *AnalysisRequest) (map[string]bool, map[string]bool) {
+	traces := make(map[string]bool)
+	observations := make(map[string]bool)
+
+	addTrace := func(t *langfuse.Trace) {
+		traces[t.ID] = true
+		for _, o := range t.Observations {
+			observations[o.ID] = true
+		}
+	}
+	if req.Trace != nil {
+		addTrace(req.Trace)
+	}
+	if req.Session != nil {
+		for i := range req.Session.Traces {
+			addTrace(&req.Session.Traces[i])
+		}
+	}
+	return traces, observations
+}
+
+// ExtractJSON находит JSON-объект в ответе модели: сам ответ, содержимое
+// markdown-блока ```json ... ``` или первый сбалансированный {...} в тексте
+func ExtractJSON(raw string) (string, bool) {
+	text := strings.TrimSpace(raw)
+	if json.Valid([]byte(text)) && strings.HasPrefix(text, "{") {
+		return text, true
+	}
+
+	// Markdown-блок
+	if start := strings.Index(text, "```"); start >= 0 {
+		body := text[start+3:]
+		if nl := strings.IndexByte(body, '\n'); nl >= 0 {
+			body = body[nl+1:] // пропускаем язык блока (```json)
+		}
+		if end := strings.Index(body, "```"); end >= 0 {
+			candidate := strings.TrimSpace(body[:end])
+			if json.Valid([]byte(candidate)) && strings.HasPrefix(candidate, "{") {
+				return candidate, true
+			}
+		}
+	}
+
+	// Первый сбалансированный объект в тексте
+	for start := strings.IndexByte(text, '{'); start >= 0; {
+		if end := matchingBrace(text, start); end > 0 {
+			candidate := text[start : end+1]
+			if json.Valid([]byte(candidate)) {
+				return candidate, true
+			}
+		}
+		next := strings.IndexByte(text[start+1:], '{')
+		if next < 0 {
+			break
+		}
+		start += next + 1
+	}
+
+	return "", false
+}
+
+// matchingBrace возвращает индекс закрывающей скобки для '{' в позиции start
+// с учетом строк и экранирования, либо -1
+func matchingBrace(text string, start int) int {
+	depth := 0
+	inString := false
+	escaped := false
+	for i := start; i < len(text); i++ {
+		ch := text[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case ch == '\\':
+				escaped = true
+			case ch == '"':
+				inString = false
+			}
+			continue
+		}
+		switch ch {
+		case '"':
+			inString = true
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func containsEnum[T ~string](values []T, value T) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func joinEnum[T ~string](values []T) string {
+	return strings.Join(enumStrings(values), " | ")
+}
+
+func enumStrings[T ~string](values []T) []string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = string(v)
+	}
+	return parts
+}
@@ -0,0 +1,302 @@
+package ai
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"langfuse-analyzer-backend/langfuse"
+)
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+		ok   bool
+	}{
+		{
+			name: "plain object",
+			raw:  `  {"a": 1}  `,
+			want: `{"a": 1}`,
+			ok:   true,
+		},
+		{
+			name: "fenced json block",
+			raw:  "Вот отчет:\n```json\n{\"a\": 1}\n```\nГотово.",
+			want: `{"a": 1}`,
+			ok:   true,
+		},
+		{
+			name: "fenced block without language",
+			raw:  "```\n{\"a\": {\"b\": 2}}\n```",
+			want: `{"a": {"b": 2}}`,
+			ok:   true,
+		},
+		{
+			name: "prose before",
+			raw:  `Here is the analysis: {"a": 1}`,
+			want: `{"a": 1}`,
+			ok:   true,
+		},
+		{
+			name: "prose after",
+			raw:  `{"a": 1} Let me know if you need more.`,
+			want: `{"a": 1}`,
+			ok:   true,
+		},
+		{
+			name: "prose around with nested object",
+			raw:  `Result: {"a": {"b": [1, 2]}, "c": "d"} — end`,
+			want: `{"a": {"b": [1, 2]}, "c": "d"}`,
+			ok:   true,
+		},
+		{
+			name: "braces inside strings",
+			raw:  `Ответ: {"text": "use {x} and }{", "n": 1} конец`,
+			want: `{"text": "use {x} and }{", "n": 1}`,
+			ok:   true,
+		},
+		{
+			name: "escaped quote before brace in string",
+			raw:  `x {"text": "say \"}\" now"} y`,
+			want: `{"text": "say \"}\" now"}`,
+			ok:   true,
+		},
+		{
+			name: "invalid first object, valid second",
+			raw:  `{not json} and then {"a": 1}`,
+			want: `{"a": 1}`,
+			ok:   true,
+		},
+		{
+			name: "no object",
+			raw:  "Не удалось проанализировать трейс",
+			ok:   false,
+		},
+		{
+			name: "unclosed outer object falls back to balanced inner",
+			raw:  `{"a": {"b": 1}`,
+			want: `{"b": 1}`,
+			ok:   true,
+		},
+		{
+			name: "unclosed object",
+			raw:  `{"a": "b"`,
+			ok:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ExtractJSON(tt.raw)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("ExtractJSON() = %q, %t; want %q, %t", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+// testRequest - запрос с трейсом из двух наблюдений
+func testRequest(language string) *AnalysisRequest {
+	return &AnalysisRequest{
+		Trace: &langfuse.Trace{
+			ID: "trace-1",
+			Observations: []langfuse.Observation{
+				{ID: "obs-1", Name: "search"},
+				{ID: "obs-2", Name: "answer"},
+			},
+		},
+		Language: language,
+	}
+}
+
+const ruReport = `{
+  "analysisSummary": {"overallStatus": "warning", "keyFinding": "Поиск выполнялся дольше остальных шагов"},
+  "detailedAnalysis": {
+    "anomalyType": "performance bottleneck",
+    "description": "Большая часть времени трейса приходится на шаг поиска",
+    "rootCause": "Поиск выполняется последовательно по всем источникам",
+    "recommendation": "Запрашивать источники параллельно и кэшировать ответы"
+  },
+  "findings": [{
+    "type": "PERFORMANCE_BOTTLENECK",
+    "severity": "medium",
+    "observationIds": ["obs-1"],
+    "evidence": {"latency": 12.5},
+    "description": "Шаг поиска занял двенадцать секунд из четырнадцати",
+    "recommendation": "Ограничить время ожидания каждого источника"
+  }]
+}`
+
+const enReport = `{
+  "analysisSummary": {"overallStatus": "WARNING", "keyFinding": "The search step took longer than all other steps"},
+  "detailedAnalysis": {
+    "anomalyType": "PERFORMANCE_BOTTLENECK",
+    "description": "Most of the trace time is spent in the search step",
+    "rootCause": "Search queries every source sequentially",
+    "recommendation": "Query the sources in parallel and cache the answers"
+  },
+  "findings": [{
+    "type": "PERFORMANCE_BOTTLENECK",
+    "severity": "MEDIUM",
+    "observationIds": ["obs-1"],
+    "description": "The search step took twelve of fourteen seconds",
+    "recommendation": "Limit the time spent waiting for each source"
+  }]
+}`
+
+func TestParseAnalysisResult(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		language string
+		problems []string // подстроки, которые должны быть среди нарушений; nil - отчет корректен
+	}{
+		{
+			name:     "valid, enums normalized",
+			raw:      ruReport,
+			language: "ru",
+		},
+		{
+			name:     "valid in fenced block with prose",
+			raw:      "Анализ готов.\n```json\n" + ruReport + "\n```\nЕсли нужно, уточню.",
+			language: "ru",
+		},
+		{
+			name:     "valid english",
+			raw:      enReport,
+			language: "en",
+		},
+		{
+			name:     "no json",
+			raw:      "Извините, не могу проанализировать этот трейс.",
+			language: "ru",
+			problems: []string{"не содержит JSON"},
+		},
+		{
+			name:     "unknown overall status",
+			raw:      strings.Replace(ruReport, `"warning"`, `"CRITICAL"`, 1),
+			language: "ru",
+			problems: []string{`analysisSummary.overallStatus = "CRITICAL"`},
+		},
+		{
+			name:     "unknown anomaly type",
+			raw:      strings.Replace(ruReport, `"performance bottleneck"`, `"SLOW"`, 1),
+			language: "ru",
+			problems: []string{`detailedAnalysis.anomalyType = "SLOW"`},
+		},
+		{
+			name:     "session anomaly in trace report",
+			raw:      strings.Replace(ruReport, `"performance bottleneck"`, `"CONTEXT_LOSS"`, 1),
+			language: "ru",
+			problems: []string{`detailedAnalysis.anomalyType = "CONTEXT_LOSS"`},
+		},
+		{
+			name:     "unknown severity",
+			raw:      strings.Replace(ruReport, `"medium"`, `"URGENT"`, 1),
+			language: "ru",
+			problems: []string{`findings[0].severity = "URGENT"`},
+		},
+		{
+			name:     "unknown observationId",
+			raw:      strings.Replace(ruReport, `["obs-1"]`, `["obs-1", "obs-404"]`, 1),
+			language: "ru",
+			problems: []string{`findings[0].observationIds: наблюдения "obs-404" нет в трейсе`},
+		},
+		{
+			name:     "findings missing for anomaly",
+			raw:      ruReport[:strings.Index(ruReport, `"findings"`)] + `"findings": []}`,
+			language: "ru",
+			problems: []string{"findings пуст"},
+		},
+		{
+			name:     "english report for russian request",
+			raw:      enReport,
+			language: "ru",
+			problems: []string{"analysisSummary.keyFinding написано не на языке ru", "findings[0].description написано не на языке ru"},
+		},
+		{
+			name:     "russian report for english request",
+			raw:      ruReport,
+			language: "en",
+			problems: []string{"detailedAnalysis.description написано не на языке en"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseAnalysisResult(tt.raw, testRequest(tt.language))
+			if tt.problems == nil {
+				if err != nil {
+					t.Fatalf("ParseAnalysisResult() error = %v", err)
+				}
+				if result.AnalysisSummary.OverallStatus != StatusWarning ||
+					result.DetailedAnalysis.AnomalyType != AnomalyPerformanceBottleneck ||
+					result.Findings[0].Severity != SeverityMedium {
+					t.Errorf("enums not normalized: %+v", result)
+				}
+				return
+			}
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("ParseAnalysisResult() error = %v, want *ValidationError", err)
+			}
+			if validationErr.Raw != tt.raw {
+				t.Error("ValidationError.Raw does not hold the model answer")
+			}
+			all := strings.Join(validationErr.Problems, "\n")
+			for _, want := range tt.problems {
+				if !strings.Contains(all, want) {
+					t.Errorf("problems = %q, want one containing %q", validationErr.Problems, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSession(t *testing.T) {
+	req := &AnalysisRequest{
+		Session: &langfuse.Session{
+			ID: "session-1",
+			Traces: []langfuse.Trace{
+				{ID: "trace-1", Observations: []langfuse.Observation{{ID: "obs-1"}}},
+				{ID: "trace-2"},
+			},
+		},
+	}
+	result := &AnalysisResult{
+		AnalysisSummary: AnalysisSummary{OverallStatus: StatusError, KeyFinding: "Ошибка повторяется"},
+		DetailedAnalysis: DetailedAnalysis{
+			AnomalyType:    AnomalyRepeatedFailure,
+			Description:    "Описание",
+			RootCause:      "Причина",
+			Recommendation: "Рекомендация",
+		},
+		TraceReferences: []TraceReference{{TraceID: "trace-2", Turn: 2, Status: "BROKEN"}},
+		Findings: []Finding{{
+			Type:           AnomalyRepeatedFailure,
+			Severity:       SeverityHigh,
+			TraceIDs:       []string{"trace-1", "trace-9"},
+			ObservationIDs: []string{"obs-1"},
+			Description:    "Описание",
+			Recommendation: "Рекомендация",
+		}},
+	}
+
+	problems := result.Validate(req)
+	want := []string{
+		`findings[0].traceIds: трейса "trace-9" нет в сессии`,
+		`traceReferences[0].status = "BROKEN"`,
+	}
+	all := strings.Join(problems, "\n")
+	for _, w := range want {
+		if !strings.Contains(all, w) {
+			t.Errorf("problems = %q, want one containing %q", problems, w)
+		}
+	}
+	if len(problems) != len(want) {
+		t.Errorf("problems = %q, want exactly %d", problems, len(want))
+	}
+}
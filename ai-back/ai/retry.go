@@ -0,0 +1,128 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// RetryConfig - настройки повторов запроса к провайдеру
+type RetryConfig struct {
+	MaxAttempts int           // обращений к провайдеру на один запрос, включая первое
+	Budget      time.Duration // суммарное ожидание между повторами, не больше
+	BaseDelay   time.Duration // пауза перед первым повтором, если провайдер ее не подсказал
+}
+
+// DefaultRetryConfig - настройки повторов по умолчанию
+func DefaultRetryConfig() RetryConfig {
+	return RetryConfig{
+		MaxAttempts: 3,
+		Budget:      30 * time.Second,
+		BaseDelay:   2 * time.Second,
+	}
+}
+
+// RetryClient - AIClient, повторяющий запрос, когда провайдер просит
+// подождать: 429 или другая ошибка с RetryAfter (например, 529 overloaded
+// у Anthropic). Ждет столько, сколько подсказал провайдер (заголовок
+// Retry-After или текст ошибки), без подсказки - с удвоением BaseDelay.
+// Если следующее ожидание не укладывается в Budget или в дедлайн
+// контекста, возвращает последнюю ошибку сразу: клиент получит 429
+// с retryAfter только тогда, когда бюджет исчерпан.
+type RetryClient struct {
+	name   string
+	client AIClient
+	cfg    RetryConfig
+}
+
+// NewRetryClient оборачивает client повторами; name - имя провайдера в логах
+func NewRetryClient(name string, client AIClient, cfg RetryConfig) *RetryClient {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 1
+	}
+	if cfg.BaseDelay <= 0 {
+		cfg.BaseDelay = DefaultRetryConfig().BaseDelay
+	}
+	return &RetryClient{name: name, client: client, cfg: cfg}
+}
+
+// AnalyzeTrace - анализ с повторами
+func (c *RetryClient) AnalyzeTrace(ctx context.Context, req *AnalysisRequest) (string, error) {
+	return c.retry(ctx, func() (string, error) {
+		return c.client.AnalyzeTrace(ctx, req)
+	}, nil)
+}
+
+// AnalyzeTraceStream - потоковый анализ с повторами. Запрос повторяется,
+// только если клиенту еще не отправлено ни одного токена.
+func (c *RetryClient) AnalyzeTraceStream(ctx context.Context, req *AnalysisRequest, onToken func(string)) (string, error) {
+	streamed := false
+	return c.retry(ctx, func() (string, error) {
+		return c.client.AnalyzeTraceStream(ctx, req, func(token string) {
+			streamed = true
+			onToken(token)
+		})
+	}, func() bool { return streamed })
+}
+
+// Close закрывает вложенного клиента
+func (c *RetryClient) Close() error {
+	return Close(c.client)
+}
+
+// retry вызывает call, пока тот не ответит, ошибка не перестанет быть
+// временной или не кончатся попытки и бюджет ожидания
+func (c *RetryClient) retry(ctx context.Context, call func() (string, error), streamed func() bool) (string, error) {
+	var waited time.Duration
+	for attempt := 1; ; attempt++ {
+		content, err := call()
+		if err == nil {
+			return content, nil
+		}
+		if attempt >= c.cfg.MaxAttempts || (streamed != nil && streamed()) {
+			return "", err
+		}
+
+		delay, ok := c.delay(err, attempt)
+		if !ok {
+			return "", err
+		}
+		if waited+delay > c.cfg.Budget {
+			log.Printf("⏳ Провайдер %s просит подождать %s, бюджет ожидания исчерпан (%s из %s)",
+				c.name, delay, waited, c.cfg.Budget)
+			return "", err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return "", err
+		}
+
+		log.Printf("⏳ Провайдер %s: %v. Повтор через %s (попытка %d из %d)",
+			c.name, err, delay, attempt+1, c.cfg.MaxAttempts)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", err
+		case <-timer.C:
+		}
+		waited += delay
+	}
+}
+
+// delay - сколько ждать перед повтором после ошибки err; false, если
+// ошибка не временная и повторять запрос бессмысленно
+func (c *RetryClient) delay(err error, attempt int) (time.Duration, bool) {
+	var aiErr *AIError
+	if !errors.As(err, &aiErr) {
+		return 0, false
+	}
+	if aiErr.RetryAfter > 0 {
+		return time.Duration(aiErr.RetryAfter) * time.Second, true
+	}
+	if aiErr.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	return c.cfg.BaseDelay << (attempt - 1), true
+}
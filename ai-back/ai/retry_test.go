@@ -0,0 +1,187 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func rateLimited(retryAfter int) func() error {
+	return func() error {
+		return &AIError{StatusCode: http.StatusTooManyRequests, Message: "rate limit", RetryAfter: retryAfter}
+	}
+}
+
+func TestRetryHonorsRetryAfter(t *testing.T) {
+	client := &scriptedClient{errs: repeatErr(1, rateLimited(1))}
+	retry := NewRetryClient("test/model", client, RetryConfig{MaxAttempts: 3, Budget: 5 * time.Second, BaseDelay: time.Millisecond})
+
+	started := time.Now()
+	content, err := retry.AnalyzeTrace(context.Background(), &AnalysisRequest{})
+	elapsed := time.Since(started)
+
+	if err != nil || content != "{}" {
+		t.Fatalf("AnalyzeTrace() = %q, %v; want success after retry", content, err)
+	}
+	if client.callCount() != 2 {
+		t.Errorf("provider calls = %d, want 2", client.callCount())
+	}
+	if elapsed < time.Second {
+		t.Errorf("retried after %s, want at least Retry-After = 1s", elapsed)
+	}
+}
+
+func TestRetryBackoffWithoutHint(t *testing.T) {
+	client := &scriptedClient{errs: repeatErr(2, rateLimited(0))}
+	retry := NewRetryClient("test/model", client, RetryConfig{MaxAttempts: 3, Budget: time.Second, BaseDelay: 10 * time.Millisecond})
+
+	started := time.Now()
+	if _, err := retry.AnalyzeTrace(context.Background(), &AnalysisRequest{}); err != nil {
+		t.Fatalf("AnalyzeTrace() error = %v", err)
+	}
+	// 10ms, затем 20ms
+	if elapsed := time.Since(started); elapsed < 30*time.Millisecond {
+		t.Errorf("retried after %s, want doubling BaseDelay (>= 30ms)", elapsed)
+	}
+	if client.callCount() != 3 {
+		t.Errorf("provider calls = %d, want 3", client.callCount())
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	tests := []struct {
+		name  string
+		errs  []error
+		cfg   RetryConfig
+		ctx   func() (context.Context, context.CancelFunc)
+		calls int
+	}{
+		{
+			name:  "wait exceeds budget",
+			errs:  repeatErr(1, rateLimited(2)),
+			cfg:   RetryConfig{MaxAttempts: 3, Budget: time.Second},
+			calls: 1,
+		},
+		{
+			name:  "budget spent by earlier waits",
+			errs:  repeatErr(3, rateLimited(0)),
+			cfg:   RetryConfig{MaxAttempts: 5, Budget: 25 * time.Millisecond, BaseDelay: 10 * time.Millisecond},
+			calls: 2, // 10ms укладывается, 10+20ms уже нет
+		},
+		{
+			name: "wait exceeds context deadline",
+			errs: repeatErr(1, rateLimited(1)),
+			cfg:  RetryConfig{MaxAttempts: 3, Budget: time.Minute},
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 200*time.Millisecond)
+			},
+			calls: 1,
+		},
+		{
+			name:  "attempts exhausted",
+			errs:  repeatErr(3, rateLimited(0)),
+			cfg:   RetryConfig{MaxAttempts: 2, Budget: time.Second, BaseDelay: time.Millisecond},
+			calls: 2,
+		},
+		{
+			name:  "not a temporary error",
+			errs:  []error{&AIError{StatusCode: http.StatusBadRequest, Message: "bad request"}},
+			cfg:   RetryConfig{MaxAttempts: 3, Budget: time.Second, BaseDelay: time.Millisecond},
+			calls: 1,
+		},
+		{
+			name:  "unavailable without hint",
+			errs:  []error{unavailable()},
+			cfg:   RetryConfig{MaxAttempts: 3, Budget: time.Second, BaseDelay: time.Millisecond},
+			calls: 1,
+		},
+		{
+			name:  "not a provider error",
+			errs:  []error{errors.New("network down")},
+			cfg:   RetryConfig{MaxAttempts: 3, Budget: time.Second, BaseDelay: time.Millisecond},
+			calls: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			if tt.ctx != nil {
+				ctx, cancel = tt.ctx()
+			}
+			defer cancel()
+
+			last := tt.errs[tt.calls-1]
+			client := &scriptedClient{errs: tt.errs}
+			retry := NewRetryClient("test/model", client, tt.cfg)
+
+			started := time.Now()
+			_, err := retry.AnalyzeTrace(ctx, &AnalysisRequest{})
+			if err != last {
+				t.Errorf("error = %v, want the last provider error %v", err, last)
+			}
+			if client.callCount() != tt.calls {
+				t.Errorf("provider calls = %d, want %d", client.callCount(), tt.calls)
+			}
+			if elapsed := time.Since(started); elapsed > 500*time.Millisecond {
+				t.Errorf("gave up after %s, want no long wait", elapsed)
+			}
+		})
+	}
+}
+
+func TestRetryStopsOnCancel(t *testing.T) {
+	client := &scriptedClient{errs: repeatErr(1, rateLimited(1))}
+	retry := NewRetryClient("test/model", client, RetryConfig{MaxAttempts: 3, Budget: time.Minute})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	started := time.Now()
+	if _, err := retry.AnalyzeTrace(ctx, &AnalysisRequest{}); err == nil {
+		t.Fatal("want error after cancellation")
+	}
+	if elapsed := time.Since(started); elapsed > 500*time.Millisecond {
+		t.Errorf("waited %s after cancellation", elapsed)
+	}
+	if client.callCount() != 1 {
+		t.Errorf("provider calls = %d, want 1", client.callCount())
+	}
+}
+
+func TestRetryStream(t *testing.T) {
+	cfg := RetryConfig{MaxAttempts: 3, Budget: time.Second, BaseDelay: time.Millisecond}
+
+	t.Run("retried before first token", func(t *testing.T) {
+		client := &scriptedClient{errs: repeatErr(1, rateLimited(0))}
+		retry := NewRetryClient("test/model", client, cfg)
+
+		if _, err := retry.AnalyzeTraceStream(context.Background(), &AnalysisRequest{}, func(string) {}); err != nil {
+			t.Fatalf("AnalyzeTraceStream() error = %v", err)
+		}
+		if client.callCount() != 2 {
+			t.Errorf("provider calls = %d, want 2", client.callCount())
+		}
+	})
+
+	t.Run("not retried after first token", func(t *testing.T) {
+		client := &scriptedClient{errs: repeatErr(1, rateLimited(0)), tokens: []string{`{"analysis`}}
+		retry := NewRetryClient("test/model", client, cfg)
+
+		var received []string
+		_, err := retry.AnalyzeTraceStream(context.Background(), &AnalysisRequest{}, func(token string) {
+			received = append(received, token)
+		})
+		if err == nil {
+			t.Fatal("want the provider error: the stream has already started")
+		}
+		if client.callCount() != 1 {
+			t.Errorf("provider calls = %d, want 1", client.callCount())
+		}
+		if len(received) != 1 {
+			t.Errorf("tokens = %q, want the one token sent before the error", received)
+		}
+	})
+}
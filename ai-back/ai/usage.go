@@ -0,0 +1,35 @@
+package ai
+
+import "context"
+
+// Usage - токены, потраченные на один запрос к модели, по данным провайдера
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+}
+
+type usageKey struct{}
+
+// WithUsage возвращает контекст, в который клиент провайдера запишет
+// расход токенов запроса. Один контекст - один запрос к модели.
+func WithUsage(ctx context.Context) (context.Context, *Usage) {
+	usage := &Usage{}
+	return context.WithValue(ctx, usageKey{}, usage), usage
+}
+
+// recordUsage записывает расход токенов, если контекст создан WithUsage.
+// Ненулевые значения заменяют прежние: в потоке провайдеры присылают
+// нарастающий итог (Gemini) или токены запроса и ответа в разных событиях
+// (Anthropic).
+func recordUsage(ctx context.Context, prompt, completion int) {
+	usage, ok := ctx.Value(usageKey{}).(*Usage)
+	if !ok {
+		return
+	}
+	if prompt > 0 {
+		usage.PromptTokens = prompt
+	}
+	if completion > 0 {
+		usage.CompletionTokens = completion
+	}
+}
@@ -0,0 +1,172 @@
+// Package apperr - таксономия ошибок API, общая для AI провайдеров и Langfuse.
+// Источники ошибок (ai, langfuse) превращают свои ошибки в *Error с
+// кодом из списка ниже; обработчики HTTP отдают их клиенту в едином формате.
+package apperr
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Code - стабильный код ошибки для клиентов API
+type Code string
+
+const (
+	// CodeUpstreamUnavailable - AI провайдер или Langfuse недоступен (5xx, нет соединения)
+	CodeUpstreamUnavailable Code = "UPSTREAM_UNAVAILABLE"
+	// CodeUpstreamError - внешний сервис отклонил запрос или вернул некорректный ответ
+	CodeUpstreamError Code = "UPSTREAM_ERROR"
+	// CodeTraceNotFound - трейса нет в Langfuse
+	CodeTraceNotFound Code = "TRACE_NOT_FOUND"
+	// CodeSessionNotFound - сессии нет в Langfuse
+	CodeSessionNotFound Code = "SESSION_NOT_FOUND"
+	// CodeAuthFailed - AI провайдер или Langfuse отклонил ключи API сервера
+	CodeAuthFailed Code = "AUTH_FAILED"
+	// CodeRateLimited - превышен лимит запросов; повторить через RetryAfter
+	CodeRateLimited Code = "RATE_LIMIT"
+	// CodeInsufficientCredits - закончились кредиты у AI провайдера
+	CodeInsufficientCredits Code = "INSUFFICIENT_CREDITS"
+	// CodeInvalidModelOutput - модель так и не вернула отчет в нужном формате
+	CodeInvalidModelOutput Code = "INVALID_MODEL_OUTPUT"
+	// CodeTimeout - внешний сервис не ответил вовремя
+	CodeTimeout Code = "TIMEOUT"
+	// CodeInvalidRequest - некорректный запрос клиента
+	CodeInvalidRequest Code = "INVALID_REQUEST"
+	// CodeUnsupportedLanguage - язык отчета не поддерживается
+	CodeUnsupportedLanguage Code = "UNSUPPORTED_LANGUAGE"
+	// CodeJobNotFound - задачи нет в очереди
+	CodeJobNotFound Code = "JOB_NOT_FOUND"
+	// CodeJobFinished - задача уже завершена и не может быть отменена
+	CodeJobFinished Code = "JOB_FINISHED"
+	// CodeQueueFull - очередь задач переполнена
+	CodeQueueFull Code = "QUEUE_FULL"
+	// CodeShuttingDown - сервер останавливается и новые задачи не принимает
+	CodeShuttingDown Code = "SHUTTING_DOWN"
+	// CodeCanceled - запрос отменен клиентом
+	CodeCanceled Code = "CANCELED"
+	// CodeInternal - непредвиденная ошибка сервера
+	CodeInternal Code = "INTERNAL"
+)
+
+// statusClientClosedRequest - клиент закрыл соединение, не дождавшись ответа
+const statusClientClosedRequest = 499
+
+// HTTPStatus - HTTP статус ответа для кода
+func (c Code) HTTPStatus() int {
+	switch c {
+	case CodeUpstreamUnavailable, CodeQueueFull, CodeShuttingDown:
+		return http.StatusServiceUnavailable
+	case CodeUpstreamError, CodeAuthFailed, CodeInvalidModelOutput:
+		return http.StatusBadGateway
+	case CodeTraceNotFound, CodeSessionNotFound, CodeJobNotFound:
+		return http.StatusNotFound
+	case CodeRateLimited:
+		return http.StatusTooManyRequests
+	case CodeInsufficientCredits:
+		return http.StatusPaymentRequired
+	case CodeTimeout:
+		return http.StatusGatewayTimeout
+	case CodeInvalidRequest, CodeUnsupportedLanguage:
+		return http.StatusBadRequest
+	case CodeJobFinished:
+		return http.StatusConflict
+	case CodeCanceled:
+		return statusClientClosedRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Error - ошибка с кодом из таксономии
+type Error struct {
+	Code       Code
+	Message    string // текст для клиента API
+	RetryAfter int    // секунды, если повтор имеет смысл
+	Details    any    // дополнительные данные для клиента, например список проблем
+	Err        error  // исходная ошибка, только для логов
+}
+
+// New создает ошибку с кодом и текстом для клиента
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Wrap создает ошибку с кодом, текстом для клиента и исходной ошибкой
+func Wrap(code Code, message string, err error) *Error {
+	return &Error{Code: code, Message: message, Err: err}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Is сравнивает ошибки по коду, чтобы работало errors.Is(err, apperr.ErrRateLimited)
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Ошибки-образцы для errors.Is
+var (
+	ErrUpstreamUnavailable = &Error{Code: CodeUpstreamUnavailable}
+	ErrTraceNotFound       = &Error{Code: CodeTraceNotFound}
+	ErrAuthFailed          = &Error{Code: CodeAuthFailed}
+	ErrRateLimited         = &Error{Code: CodeRateLimited}
+	ErrInsufficientCredits = &Error{Code: CodeInsufficientCredits}
+	ErrInvalidModelOutput  = &Error{Code: CodeInvalidModelOutput}
+	ErrTimeout             = &Error{Code: CodeTimeout}
+)
+
+// Converter - ошибка источника, которая знает свое место в таксономии
+// (ai.AIError, ai.ValidationError, langfuse.APIError)
+type Converter interface {
+	error
+	AppError() *Error
+}
+
+// From находит для err ошибку таксономии: саму *Error, результат
+// AppError() источника или ошибку контекста. Все остальное - CodeInternal.
+func From(err error) *Error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	var conv Converter
+	if errors.As(err, &conv) {
+		return conv.AppError()
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return Wrap(CodeTimeout, "Истекло время ожидания анализа", err)
+	case errors.Is(err, context.Canceled):
+		return Wrap(CodeCanceled, "Запрос отменен", err)
+	}
+	return Wrap(CodeInternal, "Внутренняя ошибка сервера", err)
+}
+
+// ParseRetryAfter читает заголовок Retry-After ответа AI провайдера или
+// Langfuse: число секунд или HTTP-дату. Возвращает секунды для
+// Error.RetryAfter; 0, если заголовка нет, он не разобран или время прошло.
+func ParseRetryAfter(value string) int {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(seconds, 0)
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(int(math.Ceil(time.Until(at).Seconds())), 0)
+	}
+	return 0
+}
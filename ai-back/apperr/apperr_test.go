@@ -0,0 +1,33 @@
+package apperr
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  int
+	}{
+		{"empty", "", 0},
+		{"seconds", "20", 20},
+		{"seconds with spaces", " 7 ", 7},
+		{"zero", "0", 0},
+		{"negative", "-5", 0},
+		{"http date", time.Now().Add(90 * time.Second).UTC().Format(http.TimeFormat), 90},
+		{"past http date", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0},
+		{"garbage", "soon", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseRetryAfter(tt.value)
+			// HTTP-дата с точностью до секунды: допускаем округление
+			if got != tt.want && got != tt.want-1 {
+				t.Errorf("ParseRetryAfter(%q) = %d, want %d", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"langfuse-analyzer-backend/apperr"
+	"langfuse-analyzer-backend/langfuse"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BatchRequest - запрос на анализ нескольких трейсов. Нужно указать либо
+// список traceIds, либо фильтр для выборки трейсов из Langfuse.
+type BatchRequest struct {
+	TraceIDs    []string              `json:"traceIds"`
+	Filter      *langfuse.TraceFilter `json:"filter"`
+	Concurrency int                   `json:"concurrency"`
+	Language    string                `json:"language"`
+}
+
+// BatchItem - результат анализа одного трейса в пакете
+type BatchItem struct {
+	TraceID string `json:"traceId"`
+	Status  string `json:"status"` // "ok" или "error"
+
+	*AnalysisResponse
+
+	// Ошибка для status = "error" в том же формате, что и ответ с ошибкой /analyze
+	Error *ErrorResponse `json:"error,omitempty"`
+}
+
+// BatchSummary - агрегированная статистика по пакету
+type BatchSummary struct {
+	Total          int            `json:"total"`
+	Succeeded      int            `json:"succeeded"`
+	Failed         int            `json:"failed"`
+	AnomalyTypes   map[string]int `json:"anomalyTypes"`
+	OverallStatus  map[string]int `json:"overallStatus"`
+	ProcessingTime float64        `json:"processingTime"` // секунды
+}
+
+// Ограничения пакетного анализа, задаются в main
+var (
+	batchMaxTraces  = 100
+	batchMaxWorkers = 4
+)
+
+// handleAnalyzeBatch анализирует несколько трейсов с ограниченным
+// параллелизмом и возвращает результаты по каждому и сводку
+func handleAnalyzeBatch(c *gin.Context) {
+	var req BatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("❌ Ошибка парсинга JSON: %v", err)
+		c.JSON(errorResponse(c, invalidRequest("Некорректный JSON: "+err.Error())))
+		return
+	}
+	if len(req.TraceIDs) == 0 && req.Filter == nil {
+		c.JSON(errorResponse(c, invalidRequest("Не указаны traceIds или filter")))
+		return
+	}
+	language, err := resolveLanguage(req.Language)
+	if err != nil {
+		c.JSON(errorResponse(c, languageError(err)))
+		return
+	}
+
+	started := time.Now()
+	ctx := c.Request.Context()
+
+	traceIDs := req.TraceIDs
+	if len(traceIDs) == 0 {
+		filter := *req.Filter
+		if filter.Limit <= 0 || filter.Limit > batchMaxTraces {
+			filter.Limit = batchMaxTraces
+		}
+
+		log.Println("🔎 Выборка трейсов из Langfuse по фильтру")
+		traces, err := langfuseClient.ListTraces(ctx, filter)
+		if err != nil {
+			log.Printf("❌ Ошибка выборки трейсов: %v", err)
+			c.JSON(errorResponse(c, err))
+			return
+		}
+		for _, t := range traces {
+			traceIDs = append(traceIDs, t.ID)
+		}
+	}
+	if len(traceIDs) > batchMaxTraces {
+		appErr := invalidRequest("Слишком много трейсов в пакете")
+		appErr.Details = gin.H{"limit": batchMaxTraces}
+		c.JSON(errorResponse(c, appErr))
+		return
+	}
+
+	workers := req.Concurrency
+	if workers <= 0 || workers > batchMaxWorkers {
+		workers = batchMaxWorkers
+	}
+
+	log.Printf("📦 Пакетный анализ: %d трейсов, %d воркеров", len(traceIDs), workers)
+
+	// Пакет из batchMaxTraces трейсов анализируется дольше общего таймаута
+	// записи ответа (server.writeTimeout): снимаем его, как для потока.
+	// Пакет прерывается только отключением клиента или остановкой сервера.
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("⚠️  Не удалось снять таймаут записи для пакета: %v", err)
+	}
+
+	requestID := c.GetString(requestIDKey)
+	items := make([]BatchItem, len(traceIDs))
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, traceID := range traceIDs {
+		wg.Add(1)
+		go func(i int, traceID string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			items[i] = analyzeBatchItem(ctx, traceID, language, requestID)
+		}(i, traceID)
+	}
+	wg.Wait()
+
+	summary := summarizeBatch(items)
+	summary.ProcessingTime = time.Since(started).Seconds()
+
+	log.Printf("✅ Пакетный анализ завершён: %d успешно, %d с ошибками", summary.Succeeded, summary.Failed)
+	c.JSON(http.StatusOK, gin.H{
+		"results": items,
+		"summary": summary,
+	})
+}
+
+// analyzeBatchItem анализирует один трейс пакета. requestID - ID запроса
+// пакета для ошибки элемента.
+func analyzeBatchItem(ctx context.Context, traceID, language, requestID string) BatchItem {
+	item := BatchItem{TraceID: traceID}
+	if err := ctx.Err(); err != nil {
+		item.Status = "error"
+		item.Error = nestedError(apperr.Wrap(apperr.CodeCanceled, "Пакет отменен", err), requestID)
+		return item
+	}
+
+	resp, err := runAnalysis(ctx, traceID, language)
+	if err != nil {
+		item.Status = "error"
+		item.Error = nestedError(err, requestID)
+		return item
+	}
+
+	item.Status = "ok"
+	item.AnalysisResponse = resp
+	return item
+}
+
+// summarizeBatch считает распределение anomalyType и overallStatus
+func summarizeBatch(items []BatchItem) BatchSummary {
+	summary := BatchSummary{
+		Total:         len(items),
+		AnomalyTypes:  make(map[string]int),
+		OverallStatus: make(map[string]int),
+	}
+
+	for _, item := range items {
+		if item.Status != "ok" {
+			summary.Failed++
+			continue
+		}
+		summary.Succeeded++
+		summary.OverallStatus[string(item.Data.AnalysisSummary.OverallStatus)]++
+		summary.AnomalyTypes[string(item.Data.DetailedAnalysis.AnomalyType)]++
+	}
+
+	return summary
+}
@@ -0,0 +1,379 @@
+package compact
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"langfuse-analyzer-backend/langfuse"
+)
+
+// Причины, по которым данные поля были сокращены
+const (
+	ReasonBase64    = "base64"    // бинарные данные в base64 заменены пометкой
+	ReasonRepeated  = "repeated"  // значение совпадает с более ранним наблюдением
+	ReasonTruncated = "truncated" // длинные строки и массивы обрезаны с сохранением начала и конца
+	ReasonDropped   = "dropped"   // поле удалено целиком, чтобы уложиться в бюджет
+)
+
+// Budget - ограничения размера трейса, отправляемого модели
+type Budget struct {
+	MaxTokens     int // оценка токенов на JSON трейса; 0 - без ограничения
+	MaxFieldChars int // начальный предел длины строки, уменьшается вдвое, пока трейс не уложится в бюджет
+	MinFieldChars int // предел, ниже которого строки не обрезаются; дальше поля удаляются целиком
+	MaxItems      int // элементов длинного массива остается: половина с начала, половина с конца
+	MinBlobChars  int // строки base64 короче этого не считаются бинарными данными
+}
+
+// DefaultBudget возвращает ограничения, рассчитанные на модели с контекстом от 16K токенов
+func DefaultBudget() Budget {
+	return Budget{
+		MaxTokens:     12000,
+		MaxFieldChars: 4000,
+		MinFieldChars: 200,
+		MaxItems:      20,
+		MinBlobChars:  512,
+	}
+}
+
+// Elision - сокращение одного поля input, output или metadata
+type Elision struct {
+	ObservationID string `json:"observationId,omitempty"` // пусто - поле самого трейса
+	Field         string `json:"field"`
+	Reason        string `json:"reason"`
+	Chars         int    `json:"chars"` // сколько символов JSON убрано
+}
+
+// maxElisions - сколько сокращений перечисляется в отчете поименно
+const maxElisions = 100
+
+// Report - что было сокращено при подготовке трейса для модели
+type Report struct {
+	OriginalTokens        int       `json:"originalTokens"`
+	CompactedTokens       int       `json:"compactedTokens"`
+	Budget                int       `json:"budget"`
+	OverBudget            bool      `json:"overBudget,omitempty"` // не удалось уложиться даже после удаления полей
+	DroppedBlobs          int       `json:"droppedBlobs"`
+	CollapsedObservations int       `json:"collapsedObservations"`
+	TruncatedFields       int       `json:"truncatedFields"`
+	DroppedFields         int       `json:"droppedFields"`
+	Elisions              []Elision `json:"elisions,omitempty"` // первые maxElisions сокращений
+}
+
+// Compacted сообщает, было ли что-то сокращено
+func (r *Report) Compacted() bool {
+	return r.DroppedBlobs+r.CollapsedObservations+r.TruncatedFields+r.DroppedFields > 0
+}
+
+// Merge добавляет к отчету сокращения другого трейса (для сессий)
+func (r *Report) Merge(other *Report) {
+	r.OriginalTokens += other.OriginalTokens
+	r.CompactedTokens += other.CompactedTokens
+	r.Budget += other.Budget
+	r.OverBudget = r.OverBudget || other.OverBudget
+	r.DroppedBlobs += other.DroppedBlobs
+	r.CollapsedObservations += other.CollapsedObservations
+	r.TruncatedFields += other.TruncatedFields
+	r.DroppedFields += other.DroppedFields
+	for _, e := range other.Elisions {
+		r.addElision(e)
+	}
+}
+
+func (r *Report) addElision(e Elision) {
+	if len(r.Elisions) < maxElisions {
+		r.Elisions = append(r.Elisions, e)
+	}
+}
+
+// EstimateTokens оценивает число токенов в JSON значения. Берется длина в
+// байтах, деленная на 4: для латиницы это близко к реальным токенизаторам,
+// а кириллица в UTF-8 занимает вдвое больше байт и токенов.
+func EstimateTokens(v interface{}) int {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	return (len(data) + 3) / 4
+}
+
+// payload - поле с произвольными данными, которое можно сокращать
+type payload struct {
+	observationID string
+	field         string
+	value         *interface{}
+}
+
+// Trace возвращает копию трейса, уложенную в бюджет, и отчет о сокращениях.
+// Исходный трейс не изменяется. Структурные поля (ID, время, уровни, usage,
+// стоимость) сохраняются всегда, сокращаются только input, output и metadata:
+//  1. бинарные данные в base64 заменяются пометкой;
+//  2. input и output наблюдений, повторяющих более раннее наблюдение с тем же
+//     именем и input, заменяются ссылкой на него;
+//  3. если трейс все еще больше бюджета, длинные строки и массивы обрезаются
+//     с сохранением начала и конца, с уменьшением предела вдвое;
+//  4. если и этого мало, поля удаляются целиком, начиная с самых больших.
+func Trace(trace *langfuse.Trace, b Budget) (*langfuse.Trace, *Report) {
+	compacted := *trace
+	compacted.Observations = append([]langfuse.Observation(nil), trace.Observations...)
+
+	report := &Report{
+		OriginalTokens: EstimateTokens(trace),
+		Budget:         b.MaxTokens,
+	}
+	payloads := collectPayloads(&compacted)
+
+	if b.MinBlobChars > 0 {
+		for _, p := range payloads {
+			value, elided := dropBlobs(*p.value, b.MinBlobChars)
+			if elided > 0 {
+				*p.value = value
+				report.DroppedBlobs++
+				report.addElision(Elision{ObservationID: p.observationID, Field: p.field, Reason: ReasonBase64, Chars: elided})
+			}
+		}
+	}
+
+	collapseRepeats(&compacted, report)
+
+	withinBudget := func() bool {
+		return b.MaxTokens <= 0 || EstimateTokens(&compacted) <= b.MaxTokens
+	}
+
+	if !withinBudget() && b.MaxFieldChars > 0 {
+		base := make([]interface{}, len(payloads))
+		for i, p := range payloads {
+			base[i] = *p.value
+		}
+
+		var truncated []Elision
+		for limit := b.MaxFieldChars; limit >= b.MinFieldChars && !withinBudget(); limit /= 2 {
+			truncated = truncated[:0]
+			for i, p := range payloads {
+				value, elided := truncateValue(base[i], limit, b.MaxItems)
+				*p.value = value
+				if elided > 0 {
+					truncated = append(truncated, Elision{ObservationID: p.observationID, Field: p.field, Reason: ReasonTruncated, Chars: elided})
+				}
+			}
+		}
+		report.TruncatedFields = len(truncated)
+		for _, e := range truncated {
+			report.addElision(e)
+		}
+	}
+
+	if !withinBudget() {
+		sort.SliceStable(payloads, func(i, j int) bool {
+			return jsonSize(*payloads[i].value) > jsonSize(*payloads[j].value)
+		})
+		for _, p := range payloads {
+			if withinBudget() {
+				break
+			}
+			if *p.value == nil {
+				continue
+			}
+			size := jsonSize(*p.value)
+			*p.value = fmt.Sprintf("[dropped: %d chars]", size)
+			report.DroppedFields++
+			report.addElision(Elision{ObservationID: p.observationID, Field: p.field, Reason: ReasonDropped, Chars: size})
+		}
+	}
+
+	report.CompactedTokens = EstimateTokens(&compacted)
+	report.OverBudget = !withinBudget()
+	return &compacted, report
+}
+
+// collectPayloads возвращает ссылки на input, output и metadata трейса
+// и всех его наблюдений
+func collectPayloads(trace *langfuse.Trace) []payload {
+	payloads := []payload{
+		{field: "input", value: &trace.Input},
+		{field: "output", value: &trace.Output},
+		{field: "metadata", value: &trace.Metadata},
+	}
+	for i := range trace.Observations {
+		o := &trace.Observations[i]
+		payloads = append(payloads,
+			payload{observationID: o.ID, field: "input", value: &o.Input},
+			payload{observationID: o.ID, field: "output", value: &o.Output},
+			payload{observationID: o.ID, field: "metadata", value: &o.Metadata},
+		)
+	}
+	return payloads
+}
+
+// collapseRepeats заменяет input и output повторных вызовов операции ссылкой
+// на первый вызов с тем же именем и input. EVENT не учитываются: у них нет
+// выполнения, которое можно было бы повторить.
+func collapseRepeats(trace *langfuse.Trace, report *Report) {
+	type first struct {
+		id         string
+		outputHash string
+	}
+	seen := make(map[string]first)
+	for i := range trace.Observations {
+		o := &trace.Observations[i]
+		if o.Input == nil || o.Type == langfuse.ObservationEvent {
+			continue
+		}
+		key := o.Name + "|" + langfuse.HashValue(o.Input)
+		f, ok := seen[key]
+		if !ok {
+			seen[key] = first{id: o.ID, outputHash: langfuse.HashValue(o.Output)}
+			continue
+		}
+
+		field := "input"
+		elided := jsonSize(o.Input)
+		o.Input = fmt.Sprintf("[repeated: same input as observation %s]", f.id)
+		if o.Output != nil && langfuse.HashValue(o.Output) == f.outputHash {
+			field = "input,output"
+			elided += jsonSize(o.Output)
+			o.Output = fmt.Sprintf("[repeated: same output as observation %s]", f.id)
+		}
+		report.CollapsedObservations++
+		report.addElision(Elision{ObservationID: o.ID, Field: field, Reason: ReasonRepeated, Chars: elided})
+	}
+}
+
+// dropBlobs заменяет строки с данными в base64 пометкой. Возвращает новое
+// значение и число убранных символов; исходное значение не изменяется.
+func dropBlobs(v interface{}, minChars int) (interface{}, int) {
+	return transform(v, func(s string) (string, int) {
+		if !isBase64Blob(s, minChars) {
+			return s, 0
+		}
+		n := utf8.RuneCountInString(s)
+		return fmt.Sprintf("[base64 blob: %d chars]", n), n
+	})
+}
+
+// isBase64Blob распознает data URI с base64 и длинные строки из символов base64
+func isBase64Blob(s string, minChars int) bool {
+	if strings.HasPrefix(s, "data:") {
+		if i := strings.Index(s, ";base64,"); i > 0 && i < 100 {
+			return true
+		}
+	}
+	if len(s) < minChars {
+		return false
+	}
+	for _, r := range s {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+		case r == '+', r == '/', r == '=', r == '-', r == '_', r == '\n', r == '\r':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// truncateValue обрезает строки длиннее limit символов и массивы длиннее
+// maxItems элементов, оставляя начало и конец
+func truncateValue(v interface{}, limit, maxItems int) (interface{}, int) {
+	elided := 0
+	v, n := transform(v, func(s string) (string, int) {
+		return truncateString(s, limit)
+	})
+	elided += n
+	if maxItems > 0 {
+		v, n = truncateArrays(v, maxItems)
+		elided += n
+	}
+	return v, elided
+}
+
+// truncateString оставляет 2/3 предела с начала строки и 1/3 с конца:
+// в начале обычно инструкции, в конце - последний вопрос или итог
+func truncateString(s string, limit int) (string, int) {
+	if len(s) <= limit {
+		return s, 0
+	}
+	runes := []rune(s)
+	if len(runes) <= limit {
+		return s, 0
+	}
+	head := limit * 2 / 3
+	tail := limit - head
+	elided := len(runes) - head - tail
+	return string(runes[:head]) + fmt.Sprintf(" …[%d chars elided]… ", elided) + string(runes[len(runes)-tail:]), elided
+}
+
+// truncateArrays оставляет у длинных массивов первые и последние элементы
+func truncateArrays(v interface{}, maxItems int) (interface{}, int) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		elided := 0
+		for k, item := range val {
+			var n int
+			out[k], n = truncateArrays(item, maxItems)
+			elided += n
+		}
+		return out, elided
+	case []interface{}:
+		elided := 0
+		items := val
+		if len(val) > maxItems {
+			head := (maxItems + 1) / 2
+			tail := maxItems - head
+			dropped := val[head : len(val)-tail]
+			elided += jsonSize(dropped)
+			items = make([]interface{}, 0, maxItems+1)
+			items = append(items, val[:head]...)
+			items = append(items, fmt.Sprintf("[%d items elided]", len(dropped)))
+			items = append(items, val[len(val)-tail:]...)
+		}
+		out := make([]interface{}, len(items))
+		for i, item := range items {
+			var n int
+			out[i], n = truncateArrays(item, maxItems)
+			elided += n
+		}
+		return out, elided
+	default:
+		return v, 0
+	}
+}
+
+// transform применяет fn ко всем строкам значения, копируя объекты и массивы
+func transform(v interface{}, fn func(string) (string, int)) (interface{}, int) {
+	switch val := v.(type) {
+	case string:
+		return fn(val)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		elided := 0
+		for k, item := range val {
+			var n int
+			out[k], n = transform(item, fn)
+			elided += n
+		}
+		return out, elided
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		elided := 0
+		for i, item := range val {
+			var n int
+			out[i], n = transform(item, fn)
+			elided += n
+		}
+		return out, elided
+	default:
+		return v, 0
+	}
+}
+
+func jsonSize(v interface{}) int {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
@@ -0,0 +1,172 @@
+package compact
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"langfuse-analyzer-backend/langfuse"
+)
+
+// snapshot - JSON трейса, чтобы проверить, что исходный трейс не изменился
+func snapshot(t *testing.T, trace *langfuse.Trace) string {
+	t.Helper()
+	data, err := json.Marshal(trace)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return string(data)
+}
+
+func TestTraceWithinBudget(t *testing.T) {
+	trace := &langfuse.Trace{
+		ID:    "trace-1",
+		Input: map[string]interface{}{"question": "Сколько стоит доставка?"},
+		Observations: []langfuse.Observation{
+			{ID: "obs-1", Type: langfuse.ObservationGeneration, Name: "llm", Input: "short prompt", Output: "short answer"},
+			{ID: "obs-2", Type: langfuse.ObservationSpan, Name: "tool", Input: []interface{}{"a", "b"}},
+		},
+	}
+	before := snapshot(t, trace)
+
+	compacted, report := Trace(trace, DefaultBudget())
+
+	if !reflect.DeepEqual(compacted, trace) {
+		t.Errorf("trace within budget was changed:\n got %s\nwant %s", snapshot(t, compacted), before)
+	}
+	if report.Compacted() || report.OverBudget || len(report.Elisions) > 0 {
+		t.Errorf("report = %+v, want nothing compacted", report)
+	}
+	if report.CompactedTokens != report.OriginalTokens {
+		t.Errorf("compactedTokens = %d, originalTokens = %d, want equal", report.CompactedTokens, report.OriginalTokens)
+	}
+}
+
+func TestTraceOverBudget(t *testing.T) {
+	prompt := "НАЧАЛО " + strings.Repeat("длинный текст промпта ", 1500) + "КОНЕЦ"
+	blob := "data:image/png;base64," + strings.Repeat("iVBORw0KGgo", 100)
+	rawBlob := strings.Repeat("QUJDREVGR0g", 100)
+	trace := &langfuse.Trace{
+		ID: "trace-1",
+		Observations: []langfuse.Observation{
+			{ID: "obs-1", Type: langfuse.ObservationGeneration, Name: "llm",
+				Input: map[string]interface{}{"prompt": prompt}, Output: "ответ"},
+			{ID: "obs-2", Type: langfuse.ObservationSpan, Name: "screenshot",
+				Input: map[string]interface{}{"image": blob, "raw": rawBlob, "caption": "скриншот"}},
+			{ID: "obs-3", Type: langfuse.ObservationGeneration, Name: "llm",
+				Input: map[string]interface{}{"prompt": prompt}, Output: "ответ"},
+		},
+	}
+	before := snapshot(t, trace)
+	budget := DefaultBudget()
+	budget.MaxTokens = 2000
+
+	compacted, report := Trace(trace, budget)
+
+	if snapshot(t, trace) != before {
+		t.Error("original trace was modified")
+	}
+	if report.OverBudget || report.CompactedTokens > budget.MaxTokens {
+		t.Fatalf("report = %+v, want trace within %d tokens", report, budget.MaxTokens)
+	}
+	if report.CompactedTokens != EstimateTokens(compacted) {
+		t.Errorf("compactedTokens = %d, estimate = %d", report.CompactedTokens, EstimateTokens(compacted))
+	}
+	if report.DroppedBlobs != 1 {
+		t.Errorf("droppedBlobs = %d, want 1 (both blobs are in one field)", report.DroppedBlobs)
+	}
+	if report.CollapsedObservations != 1 {
+		t.Errorf("collapsedObservations = %d, want 1", report.CollapsedObservations)
+	}
+	if report.TruncatedFields != 1 {
+		t.Errorf("truncatedFields = %d, want 1", report.TruncatedFields)
+	}
+	if report.DroppedFields != 0 {
+		t.Errorf("droppedFields = %d, want 0: truncation is enough", report.DroppedFields)
+	}
+
+	image := compacted.Observations[1].Input.(map[string]interface{})
+	if image["image"] != fmt.Sprintf("[base64 blob: %d chars]", len(blob)) || !strings.HasPrefix(image["raw"].(string), "[base64 blob:") {
+		t.Errorf("blobs not replaced: %v", image)
+	}
+	if image["caption"] != "скриншот" {
+		t.Errorf("caption = %v, want unchanged", image["caption"])
+	}
+
+	repeated := compacted.Observations[2]
+	if repeated.Input != "[repeated: same input as observation obs-1]" || repeated.Output != "[repeated: same output as observation obs-1]" {
+		t.Errorf("repeat not collapsed: input %v, output %v", repeated.Input, repeated.Output)
+	}
+
+	truncated := compacted.Observations[0].Input.(map[string]interface{})["prompt"].(string)
+	if !strings.HasPrefix(truncated, "НАЧАЛО") || !strings.HasSuffix(truncated, "КОНЕЦ") || !strings.Contains(truncated, "chars elided") {
+		t.Errorf("prompt must keep head and tail around the elision mark, got %.80q…", truncated)
+	}
+	// Предел уменьшается вдвое, пока трейс не уложится: 4000 мало, хватает 2000
+	if n := len([]rune(truncated)); n > budget.MaxFieldChars/2+50 {
+		t.Errorf("truncated prompt has %d chars, want about %d", n, budget.MaxFieldChars/2)
+	}
+}
+
+func TestTraceStillOverBudget(t *testing.T) {
+	trace := &langfuse.Trace{ID: "trace-1"}
+	for i := 0; i < 200; i++ {
+		trace.Observations = append(trace.Observations, langfuse.Observation{
+			ID:     fmt.Sprintf("observation-%03d", i),
+			Type:   langfuse.ObservationSpan,
+			Name:   fmt.Sprintf("step-%03d", i),
+			Input:  fmt.Sprintf("input %d", i),
+			Output: strings.Repeat("x", i%190), // короче MinFieldChars: не обрезается
+		})
+	}
+	budget := DefaultBudget()
+	budget.MaxTokens = 500
+
+	compacted, report := Trace(trace, budget)
+
+	if !report.OverBudget {
+		t.Fatalf("report = %+v, want overBudget: structure alone exceeds the budget", report)
+	}
+	if report.DroppedFields != 400 {
+		t.Errorf("droppedFields = %d, want every input and output dropped", report.DroppedFields)
+	}
+	if len(report.Elisions) != maxElisions {
+		t.Errorf("elisions = %d, want capped at %d", len(report.Elisions), maxElisions)
+	}
+	// Удаление начинается с самых больших полей
+	if first := report.Elisions[0]; first.ObservationID != "observation-189" || first.Field != "output" || first.Reason != ReasonDropped {
+		t.Errorf("first elision = %+v, want the largest output dropped first", first)
+	}
+	if len(compacted.Observations) != len(trace.Observations) {
+		t.Errorf("observations = %d, want all %d kept", len(compacted.Observations), len(trace.Observations))
+	}
+	for _, o := range compacted.Observations {
+		if !strings.HasPrefix(fmt.Sprint(o.Output), "[dropped: ") {
+			t.Fatalf("observation %s output = %v, want dropped", o.ID, o.Output)
+		}
+	}
+}
+
+func TestIsBase64Blob(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want bool
+	}{
+		{"data uri", "data:image/png;base64,iVBORw0KGgo=", true},
+		{"data uri without base64", "data:text/plain,hello", false},
+		{"long base64", strings.Repeat("QUJD", 200), true},
+		{"long base64 with newlines", strings.Repeat("QUJD\n", 200), true},
+		{"short base64", "QUJDREVGR0g=", false},
+		{"long text with spaces", strings.Repeat("word ", 200), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isBase64Blob(tt.s, 512); got != tt.want {
+				t.Errorf("isBase64Blob() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package compact
+
+import (
+	"slices"
+	"sort"
+
+	"langfuse-analyzer-backend/langfuse"
+)
+
+// Split делит наблюдения трейса на части не больше maxObservations, не разрывая
+// поддеревья без необходимости: поддерево целиком попадает в одну часть, если
+// помещается в нее. Слишком большое поддерево раскладывается по дочерним
+// поддеревьям, а его корень остается в текущей части. Наблюдения с
+// неизвестным родителем считаются корнями, цикл родителей разрывается.
+// Каждое наблюдение попадает ровно в одну часть; часть - копия трейса со
+// своим подмножеством наблюдений в исходном порядке.
+func Split(trace *langfuse.Trace, maxObservations int) []*langfuse.Trace {
+	if maxObservations <= 0 || len(trace.Observations) <= maxObservations {
+		return []*langfuse.Trace{trace}
+	}
+
+	index := make(map[string]int, len(trace.Observations))
+	for i, o := range trace.Observations {
+		index[o.ID] = i
+	}
+	children := make(map[int][]int)
+	var roots []int
+	for i, o := range trace.Observations {
+		parent, ok := index[o.ParentObservationID]
+		if o.ParentObservationID == "" || !ok || parent == i {
+			roots = append(roots, i)
+			continue
+		}
+		children[parent] = append(children[parent], i)
+	}
+
+	// Наблюдения, родители которых образуют цикл, недостижимы из корней.
+	// Для каждого такого наблюдения поднимаемся по родителям до цикла:
+	// наблюдение, на котором цикл замкнулся, становится корнем, а ссылка
+	// на него от родителя отбрасывается.
+	reached := make([]bool, len(trace.Observations))
+	var mark func(i int)
+	mark = func(i int) {
+		if reached[i] {
+			return
+		}
+		reached[i] = true
+		for _, c := range children[i] {
+			mark(c)
+		}
+	}
+	for _, r := range roots {
+		mark(r)
+	}
+	for i := range trace.Observations {
+		if reached[i] {
+			continue
+		}
+		root := i
+		visited := make(map[int]bool)
+		for !visited[root] {
+			visited[root] = true
+			root = index[trace.Observations[root].ParentObservationID]
+		}
+		parent := index[trace.Observations[root].ParentObservationID]
+		children[parent] = slices.DeleteFunc(children[parent], func(c int) bool { return c == root })
+		roots = append(roots, root)
+		mark(root)
+	}
+
+	sizes := make(map[int]int, len(trace.Observations))
+	var size func(i int) int
+	size = func(i int) int {
+		if s, ok := sizes[i]; ok {
+			return s
+		}
+		s := 1
+		for _, c := range children[i] {
+			s += size(c)
+		}
+		sizes[i] = s
+		return s
+	}
+
+	var chunks [][]int
+	var current []int
+	flush := func() {
+		if len(current) > 0 {
+			chunks = append(chunks, current)
+			current = nil
+		}
+	}
+	var subtree func(i int, out []int) []int
+	subtree = func(i int, out []int) []int {
+		out = append(out, i)
+		for _, c := range children[i] {
+			out = subtree(c, out)
+		}
+		return out
+	}
+	var place func(items []int)
+	place = func(items []int) {
+		for _, i := range items {
+			s := size(i)
+			if s <= maxObservations {
+				if len(current)+s > maxObservations {
+					flush()
+				}
+				current = subtree(i, current)
+				continue
+			}
+			if len(current)+1 > maxObservations {
+				flush()
+			}
+			current = append(current, i)
+			place(children[i])
+		}
+	}
+	place(roots)
+	flush()
+
+	parts := make([]*langfuse.Trace, len(chunks))
+	for n, chunk := range chunks {
+		sort.Ints(chunk)
+		part := *trace
+		part.Observations = make([]langfuse.Observation, len(chunk))
+		for j, i := range chunk {
+			part.Observations[j] = trace.Observations[i]
+		}
+		parts[n] = &part
+	}
+	return parts
+}
@@ -0,0 +1,110 @@
+package compact
+
+import (
+	"fmt"
+	"testing"
+
+	"langfuse-analyzer-backend/langfuse"
+)
+
+// observation - наблюдение с родителем parent ("" - корень)
+func observation(id, parent string) langfuse.Observation {
+	return langfuse.Observation{ID: id, ParentObservationID: parent}
+}
+
+// checkPartition проверяет, что каждое наблюдение трейса попало ровно в
+// одну часть, части не больше max и наблюдения в них идут в исходном порядке
+func checkPartition(t *testing.T, trace *langfuse.Trace, parts []*langfuse.Trace, max int) map[string]int {
+	t.Helper()
+	order := make(map[string]int, len(trace.Observations))
+	for i, o := range trace.Observations {
+		order[o.ID] = i
+	}
+
+	partOf := make(map[string]int)
+	for n, part := range parts {
+		if part.ID != trace.ID {
+			t.Errorf("part %d: trace ID = %q, want %q", n, part.ID, trace.ID)
+		}
+		if len(part.Observations) == 0 || len(part.Observations) > max {
+			t.Errorf("part %d: %d observations, want 1..%d", n, len(part.Observations), max)
+		}
+		for j, o := range part.Observations {
+			if prev, ok := partOf[o.ID]; ok {
+				t.Errorf("observation %s is in parts %d and %d", o.ID, prev, n)
+			}
+			partOf[o.ID] = n
+			if j > 0 && order[o.ID] < order[part.Observations[j-1].ID] {
+				t.Errorf("part %d: observations out of original order", n)
+			}
+		}
+	}
+	for _, o := range trace.Observations {
+		if _, ok := partOf[o.ID]; !ok {
+			t.Errorf("observation %s is in no part", o.ID)
+		}
+	}
+	return partOf
+}
+
+func TestSplitFits(t *testing.T) {
+	trace := &langfuse.Trace{ID: "t", Observations: []langfuse.Observation{observation("a", ""), observation("b", "a")}}
+	parts := Split(trace, 2)
+	if len(parts) != 1 || parts[0] != trace {
+		t.Errorf("Split() = %d parts, want the trace itself", len(parts))
+	}
+}
+
+func TestSplitKeepsSubtrees(t *testing.T) {
+	// root
+	// ├── a ── a1, a2
+	// ├── b ── b1 ── b2, b3, b4, b5 (больше части: раскладывается по детям)
+	// └── c
+	// orphan (родителя нет в трейсе)
+	trace := &langfuse.Trace{ID: "t", Observations: []langfuse.Observation{
+		observation("root", ""),
+		observation("a", "root"), observation("a1", "a"), observation("a2", "a"),
+		observation("b", "root"), observation("b1", "b"),
+		observation("b2", "b1"), observation("b3", "b1"), observation("b4", "b1"), observation("b5", "b1"),
+		observation("c", "root"),
+		observation("orphan", "missing"),
+	}}
+
+	parts := Split(trace, 4)
+	partOf := checkPartition(t, trace, parts, 4)
+
+	for _, id := range []string{"a1", "a2"} {
+		if partOf[id] != partOf["a"] {
+			t.Errorf("%s is not in the part of its subtree root a", id)
+		}
+	}
+}
+
+func TestSplitParentCycle(t *testing.T) {
+	// x → y → z → x - цикл, w - ребенок наблюдения из цикла, self - сам себе родитель
+	trace := &langfuse.Trace{ID: "t", Observations: []langfuse.Observation{
+		observation("root", ""),
+		observation("child", "root"),
+		observation("w", "y"),
+		observation("x", "z"),
+		observation("y", "x"),
+		observation("z", "y"),
+		observation("self", "self"),
+	}}
+	for i := 0; i < 5; i++ {
+		trace.Observations = append(trace.Observations, observation(fmt.Sprintf("leaf-%d", i), "root"))
+	}
+
+	for _, max := range []int{1, 2, 3, 5} {
+		t.Run(fmt.Sprintf("max=%d", max), func(t *testing.T) {
+			parts := Split(trace, max)
+			checkPartition(t, trace, parts, max)
+		})
+	}
+
+	// Цикл целиком помещается в часть и не разрывается между частями
+	partOf := checkPartition(t, trace, Split(trace, 4), 4)
+	if partOf["x"] != partOf["y"] || partOf["y"] != partOf["z"] || partOf["w"] != partOf["y"] {
+		t.Errorf("cycle x, y, z and its child w are split across parts: %v", partOf)
+	}
+}
@@ -0,0 +1,344 @@
+// Package config собирает настройки сервиса из четырех источников. Каждый
+// следующий переопределяет предыдущий:
+//
+//  1. значения по умолчанию (Default);
+//  2. YAML или TOML файл (по расширению .toml) из флага --config или
+//     переменной CONFIG_FILE;
+//  3. переменные окружения (в том числе из .env);
+//  4. флаги командной строки: --<путь в YAML>=значение, например
+//     --server.listen=:9090 или --providers.ollama.model=llama3.1.
+//
+// Длительности в YAML и флагах задаются как "30s" или "2m", в TOML - так
+// же или числом секунд, в переменных окружения - числом секунд, как и
+// раньше (OLLAMA_TIMEOUT=300).
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Config - все настройки сервиса
+type Config struct {
+	Server    Server    `yaml:"server"`
+	AI        AI        `yaml:"ai"`
+	Providers Providers `yaml:"providers"`
+	Langfuse  Langfuse  `yaml:"langfuse"`
+	CORS      CORS      `yaml:"cors"`
+	Jobs      Jobs      `yaml:"jobs"`
+	Limits    Limits    `yaml:"limits"`
+}
+
+// Server - настройки HTTP сервера
+type Server struct {
+	Listen       string        `yaml:"listen" env:"LISTEN_ADDR"`
+	ReadTimeout  time.Duration `yaml:"readTimeout" env:"SERVER_READ_TIMEOUT"`   // чтение запроса целиком
+	WriteTimeout time.Duration `yaml:"writeTimeout" env:"SERVER_WRITE_TIMEOUT"` // ответ целиком; на поток и пакет не действует
+	IdleTimeout  time.Duration `yaml:"idleTimeout" env:"SERVER_IDLE_TIMEOUT"`   // keep-alive соединения без запросов
+	// ShutdownTimeout - сколько ждать завершения запросов и задач при
+	// остановке, прежде чем отменить их
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout" env:"SHUTDOWN_TIMEOUT"`
+}
+
+// AI - выбор провайдеров и общие настройки анализа
+type AI struct {
+	Provider         string        `yaml:"provider" env:"AI_PROVIDER"`
+	Fallback         []string      `yaml:"fallback" env:"AI_FALLBACK_PROVIDERS"` // "provider[:model]"
+	MaxTokens        int           `yaml:"maxTokens" env:"AI_MAX_TOKENS"`
+	MaxAttempts      int           `yaml:"maxAttempts" env:"AI_MAX_ATTEMPTS"`
+	Language         string        `yaml:"language" env:"ANALYSIS_LANGUAGE"`
+	PromptsDir       string        `yaml:"promptsDir" env:"PROMPTS_DIR"`
+	RetryMaxAttempts int           `yaml:"retryMaxAttempts" env:"AI_RETRY_MAX_ATTEMPTS"`
+	RetryBudget      time.Duration `yaml:"retryBudget" env:"AI_RETRY_BUDGET_SECONDS"`
+	BreakerFailures  int           `yaml:"breakerFailures" env:"AI_BREAKER_FAILURES"`
+	BreakerOpen      time.Duration `yaml:"breakerOpen" env:"AI_BREAKER_OPEN_SECONDS"`
+}
+
+// Providers - настройки каждого AI провайдера. Используются только те,
+// что указаны в ai.provider и ai.fallback.
+type Providers struct {
+	OpenRouter       OpenRouter       `yaml:"openrouter"`
+	Ollama           Ollama           `yaml:"ollama"`
+	Anthropic        Anthropic        `yaml:"anthropic"`
+	Gemini           Gemini           `yaml:"gemini"`
+	Azure            Azure            `yaml:"azure"`
+	OpenAICompatible OpenAICompatible `yaml:"openaiCompatible"`
+}
+
+// OpenRouter - настройки OpenRouter
+type OpenRouter struct {
+	APIKey  string `yaml:"apiKey" env:"AI_API_KEY" secret:"true"`
+	BaseURL string `yaml:"baseURL" env:"AI_BASE_URL"`
+	Model   string `yaml:"model" env:"AI_MODEL"`
+}
+
+// Ollama - настройки Ollama
+type Ollama struct {
+	BaseURL string        `yaml:"baseURL" env:"OLLAMA_BASE_URL"`
+	Model   string        `yaml:"model" env:"OLLAMA_MODEL"`
+	Timeout time.Duration `yaml:"timeout" env:"OLLAMA_TIMEOUT"`
+}
+
+// Anthropic - настройки Anthropic
+type Anthropic struct {
+	APIKey   string        `yaml:"apiKey" env:"ANTHROPIC_API_KEY" secret:"true"`
+	BaseURL  string        `yaml:"baseURL" env:"ANTHROPIC_BASE_URL"`
+	Model    string        `yaml:"model" env:"ANTHROPIC_MODEL"`
+	JSONMode string        `yaml:"jsonMode" env:"ANTHROPIC_JSON_MODE"` // prefill или tool
+	Timeout  time.Duration `yaml:"timeout" env:"ANTHROPIC_TIMEOUT"`
+}
+
+// Gemini - настройки Gemini
+type Gemini struct {
+	APIKey  string        `yaml:"apiKey" env:"GEMINI_API_KEY" secret:"true"`
+	BaseURL string        `yaml:"baseURL" env:"GEMINI_BASE_URL"`
+	Model   string        `yaml:"model" env:"GEMINI_MODEL"`
+	Timeout time.Duration `yaml:"timeout" env:"GEMINI_TIMEOUT"`
+}
+
+// Azure - настройки Azure OpenAI
+type Azure struct {
+	APIKey     string `yaml:"apiKey" env:"AZURE_OPENAI_API_KEY" secret:"true"`
+	Endpoint   string `yaml:"endpoint" env:"AZURE_OPENAI_ENDPOINT"`
+	Deployment string `yaml:"deployment" env:"AZURE_OPENAI_DEPLOYMENT"`
+	APIVersion string `yaml:"apiVersion" env:"AZURE_OPENAI_API_VERSION"`
+}
+
+// OpenAICompatible - настройки сервера с OpenAI-совместимым API
+type OpenAICompatible struct {
+	BaseURL     string            `yaml:"baseURL" env:"OPENAI_COMPAT_BASE_URL"`
+	APIKey      string            `yaml:"apiKey" env:"OPENAI_COMPAT_API_KEY" secret:"true"`
+	Model       string            `yaml:"model" env:"OPENAI_COMPAT_MODEL"`
+	Headers     map[string]string `yaml:"headers" env:"OPENAI_COMPAT_HEADERS" secret:"true"` // "Name: value; Other: value"
+	JSONMode    bool              `yaml:"jsonMode" env:"OPENAI_COMPAT_JSON_MODE"`
+	SystemRole  bool              `yaml:"systemRole" env:"OPENAI_COMPAT_SYSTEM_ROLE"`
+	StreamUsage bool              `yaml:"streamUsage" env:"OPENAI_COMPAT_STREAM_USAGE"` // stream_options.include_usage
+}
+
+// Langfuse - подключение к Langfuse
+type Langfuse struct {
+	BaseURL    string        `yaml:"baseURL" env:"LANGFUSE_BASEURL"`
+	PublicKey  string        `yaml:"publicKey" env:"LANGFUSE_PUBLIC_KEY"`
+	SecretKey  string        `yaml:"secretKey" env:"LANGFUSE_SECRET_KEY" secret:"true"`
+	Timeout    time.Duration `yaml:"timeout" env:"LANGFUSE_TIMEOUT"`
+	MaxRetries int           `yaml:"maxRetries" env:"LANGFUSE_MAX_RETRIES"`
+}
+
+// CORS - кому разрешены запросы из браузера
+type CORS struct {
+	ExtensionID string   `yaml:"extensionID" env:"CHROME_EXTENSION_ID"`
+	Origins     []string `yaml:"origins" env:"CORS_ORIGINS"` // дополнительные origin, через запятую
+}
+
+// AllowedOrigins - origin расширения и дополнительные origin
+func (c CORS) AllowedOrigins() []string {
+	var origins []string
+	if c.ExtensionID != "" {
+		origins = append(origins, "chrome-extension://"+c.ExtensionID)
+	}
+	return append(origins, c.Origins...)
+}
+
+// Jobs - очередь асинхронных задач
+type Jobs struct {
+	Workers   int           `yaml:"workers" env:"JOB_WORKERS"`
+	QueueSize int           `yaml:"queueSize" env:"JOB_QUEUE_SIZE"`
+	Timeout   time.Duration `yaml:"timeout" env:"JOB_TIMEOUT"`
+}
+
+// Limits - ограничения объема анализа
+type Limits struct {
+	BatchMaxTraces       int `yaml:"batchMaxTraces" env:"BATCH_MAX_TRACES"`
+	BatchConcurrency     int `yaml:"batchConcurrency" env:"BATCH_CONCURRENCY"`
+	SessionMaxTraces     int `yaml:"sessionMaxTraces" env:"SESSION_MAX_TRACES"`
+	TraceTokenBudget     int `yaml:"traceTokenBudget" env:"TRACE_TOKEN_BUDGET"` // 0 - не сокращать
+	TraceMaxFieldChars   int `yaml:"traceMaxFieldChars" env:"TRACE_MAX_FIELD_CHARS"`
+	ChunkMaxObservations int `yaml:"chunkMaxObservations" env:"CHUNK_MAX_OBSERVATIONS"`
+	ChunkMaxParts        int `yaml:"chunkMaxParts" env:"CHUNK_MAX_PARTS"`
+	ChunkConcurrency     int `yaml:"chunkConcurrency" env:"CHUNK_CONCURRENCY"`
+}
+
+// Default - конфигурация по умолчанию
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Listen:          ":8080",
+			ReadTimeout:     30 * time.Second,
+			WriteTimeout:    10 * time.Minute,
+			IdleTimeout:     2 * time.Minute,
+			ShutdownTimeout: 30 * time.Second,
+		},
+		AI: AI{
+			Provider:         "openrouter",
+			MaxTokens:        1000,
+			MaxAttempts:      3,
+			RetryMaxAttempts: 3,
+			RetryBudget:      30 * time.Second,
+			BreakerFailures:  5,
+			BreakerOpen:      30 * time.Second,
+		},
+		Providers: Providers{
+			OpenRouter: OpenRouter{
+				BaseURL: "https://openrouter.ai/api/v1",
+				Model:   "google/gemini-2.0-flash-exp:free",
+			},
+			Ollama: Ollama{
+				BaseURL: "http://localhost:11434",
+				Model:   "llama3.2",
+				Timeout: 120 * time.Second,
+			},
+			Anthropic: Anthropic{
+				Model:    "claude-sonnet-4-5",
+				JSONMode: "prefill",
+				Timeout:  120 * time.Second,
+			},
+			Gemini: Gemini{
+				Model:   "gemini-2.0-flash",
+				Timeout: 120 * time.Second,
+			},
+			Azure: Azure{APIVersion: "2024-10-21"},
+			OpenAICompatible: OpenAICompatible{
+				JSONMode:    true,
+				SystemRole:  true,
+				StreamUsage: true,
+			},
+		},
+		Langfuse: Langfuse{
+			BaseURL:    "https://cloud.langfuse.com",
+			Timeout:    30 * time.Second,
+			MaxRetries: 3,
+		},
+		Jobs: Jobs{
+			Workers:   2,
+			QueueSize: 100,
+			Timeout:   600 * time.Second,
+		},
+		Limits: Limits{
+			BatchMaxTraces:       100,
+			BatchConcurrency:     4,
+			SessionMaxTraces:     50,
+			TraceTokenBudget:     12000,
+			TraceMaxFieldChars:   4000,
+			ChunkMaxObservations: 200,
+			ChunkMaxParts:        20,
+			ChunkConcurrency:     2,
+		},
+	}
+}
+
+// Providers, которые понимает сервис
+var providerNames = []string{"openrouter", "ollama", "anthropic", "gemini", "azure", "openai-compatible"}
+
+// ChainEntry - звено цепочки провайдеров
+type ChainEntry struct {
+	Provider string
+	Model    string // пусто - модель из настроек провайдера
+}
+
+// Chain - основной провайдер и резервные в порядке обращения. Модель
+// отделяется первым двоеточием: в именах моделей двоеточия встречаются
+// ("llama3.2:3b", ":free").
+func (a AI) Chain() []ChainEntry {
+	entries := []string{a.Provider}
+	entries = append(entries, a.Fallback...)
+
+	chain := make([]ChainEntry, 0, len(entries))
+	for _, entry := range entries {
+		name, model, _ := strings.Cut(strings.TrimSpace(entry), ":")
+		chain = append(chain, ChainEntry{Provider: strings.ToLower(name), Model: model})
+	}
+	return chain
+}
+
+// Validate проверяет конфигурацию и возвращает все найденные проблемы сразу
+func (c *Config) Validate() error {
+	var errs []error
+	problem := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+	positive := func(name string, value int) {
+		if value <= 0 {
+			problem("%s должно быть больше 0, получено %d", name, value)
+		}
+	}
+
+	if c.Server.Listen == "" {
+		problem("server.listen (LISTEN_ADDR) не задан")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		problem("server.shutdownTimeout (SHUTDOWN_TIMEOUT) должен быть больше 0")
+	}
+
+	for _, entry := range c.AI.Chain() {
+		c.validateProvider(entry, problem)
+	}
+	positive("ai.maxTokens (AI_MAX_TOKENS)", c.AI.MaxTokens)
+	positive("ai.maxAttempts (AI_MAX_ATTEMPTS)", c.AI.MaxAttempts)
+
+	if c.Langfuse.PublicKey == "" || c.Langfuse.SecretKey == "" {
+		problem("langfuse.publicKey и langfuse.secretKey (LANGFUSE_PUBLIC_KEY, LANGFUSE_SECRET_KEY) должны быть заданы")
+	}
+	positive("langfuse.maxRetries (LANGFUSE_MAX_RETRIES)", c.Langfuse.MaxRetries)
+
+	if len(c.CORS.AllowedOrigins()) == 0 {
+		problem("cors.extensionID (CHROME_EXTENSION_ID) или cors.origins (CORS_ORIGINS) должны быть заданы")
+	}
+
+	positive("jobs.workers (JOB_WORKERS)", c.Jobs.Workers)
+	positive("jobs.queueSize (JOB_QUEUE_SIZE)", c.Jobs.QueueSize)
+	positive("limits.batchMaxTraces (BATCH_MAX_TRACES)", c.Limits.BatchMaxTraces)
+	positive("limits.batchConcurrency (BATCH_CONCURRENCY)", c.Limits.BatchConcurrency)
+	positive("limits.sessionMaxTraces (SESSION_MAX_TRACES)", c.Limits.SessionMaxTraces)
+	positive("limits.chunkMaxObservations (CHUNK_MAX_OBSERVATIONS)", c.Limits.ChunkMaxObservations)
+	positive("limits.chunkMaxParts (CHUNK_MAX_PARTS)", c.Limits.ChunkMaxParts)
+	positive("limits.chunkConcurrency (CHUNK_CONCURRENCY)", c.Limits.ChunkConcurrency)
+	if c.Limits.TraceTokenBudget < 0 {
+		problem("limits.traceTokenBudget (TRACE_TOKEN_BUDGET) не может быть отрицательным")
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateProvider проверяет, что для звена цепочки заданы обязательные настройки
+func (c *Config) validateProvider(entry ChainEntry, problem func(string, ...any)) {
+	p := c.Providers
+	switch entry.Provider {
+	case "openrouter":
+		if p.OpenRouter.APIKey == "" {
+			problem("providers.openrouter.apiKey (AI_API_KEY) не задан (требуется для OpenRouter)")
+		}
+	case "ollama":
+		if p.Ollama.Timeout <= 0 {
+			problem("providers.ollama.timeout (OLLAMA_TIMEOUT) должен быть больше 0")
+		}
+	case "anthropic":
+		if p.Anthropic.APIKey == "" {
+			problem("providers.anthropic.apiKey (ANTHROPIC_API_KEY) не задан (требуется для Anthropic)")
+		}
+		if p.Anthropic.JSONMode != "prefill" && p.Anthropic.JSONMode != "tool" {
+			problem("providers.anthropic.jsonMode (ANTHROPIC_JSON_MODE): неверное значение %q. Доступные: prefill, tool", p.Anthropic.JSONMode)
+		}
+		if p.Anthropic.Timeout <= 0 {
+			problem("providers.anthropic.timeout (ANTHROPIC_TIMEOUT) должен быть больше 0")
+		}
+	case "gemini":
+		if p.Gemini.APIKey == "" {
+			problem("providers.gemini.apiKey (GEMINI_API_KEY) не задан (требуется для Gemini)")
+		}
+		if p.Gemini.Timeout <= 0 {
+			problem("providers.gemini.timeout (GEMINI_TIMEOUT) должен быть больше 0")
+		}
+	case "azure":
+		if p.Azure.APIKey == "" || p.Azure.Endpoint == "" || (p.Azure.Deployment == "" && entry.Model == "") {
+			problem("providers.azure: apiKey, endpoint и deployment (AZURE_OPENAI_API_KEY, AZURE_OPENAI_ENDPOINT, AZURE_OPENAI_DEPLOYMENT) должны быть заданы")
+		}
+	case "openai-compatible":
+		if p.OpenAICompatible.BaseURL == "" || (p.OpenAICompatible.Model == "" && entry.Model == "") {
+			problem("providers.openaiCompatible: baseURL и model (OPENAI_COMPAT_BASE_URL, OPENAI_COMPAT_MODEL) должны быть заданы")
+		}
+	default:
+		problem("неизвестный AI провайдер: %q. Доступные: %s", entry.Provider, strings.Join(providerNames, ", "))
+	}
+}
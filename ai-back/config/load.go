@@ -0,0 +1,362 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Options - параметры запуска из командной строки
+type Options struct {
+	ConfigFile  string            // путь к YAML или TOML файлу, --config или CONFIG_FILE
+	PrintConfig bool              // --print-config: вывести итоговую конфигурацию и выйти
+	overrides   map[string]string // --<путь>=значение
+}
+
+// ParseFlags разбирает аргументы командной строки. Кроме --config и
+// --print-config принимается любой параметр конфигурации по его пути в
+// YAML: --server.listen=:9090 или --server.listen :9090.
+func ParseFlags(args []string) (Options, error) {
+	opts := Options{
+		ConfigFile: os.Getenv("CONFIG_FILE"),
+		overrides:  map[string]string{},
+	}
+	known := fieldPaths()
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			return opts, fmt.Errorf("неожиданный аргумент %q", arg)
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+
+		switch name {
+		case "print-config":
+			opts.PrintConfig = !hasValue || value == "true"
+			continue
+		case "config":
+		default:
+			if !known[name] {
+				return opts, fmt.Errorf("неизвестный флаг --%s", name)
+			}
+		}
+
+		if !hasValue {
+			if i+1 >= len(args) {
+				return opts, fmt.Errorf("флаг --%s требует значение", name)
+			}
+			i++
+			value = args[i]
+		}
+		if name == "config" {
+			opts.ConfigFile = value
+		} else {
+			opts.overrides[name] = value
+		}
+	}
+	return opts, nil
+}
+
+// Load собирает конфигурацию: значения по умолчанию, затем файл, затем
+// переменные окружения, затем флаги. Результат не проверяется - для
+// этого есть Validate.
+func Load(opts Options) (*Config, error) {
+	cfg := Default()
+
+	if opts.ConfigFile != "" {
+		data, err := os.ReadFile(opts.ConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения файла конфигурации: %w", err)
+		}
+		decode := decodeYAML
+		if strings.EqualFold(filepath.Ext(opts.ConfigFile), ".toml") {
+			decode = decodeTOML
+		}
+		if err := decode(data, cfg); err != nil {
+			return nil, fmt.Errorf("ошибка разбора %s: %w", opts.ConfigFile, err)
+		}
+	}
+
+	var errs []error
+	walk(cfg, func(path string, field reflect.StructField, value reflect.Value) {
+		env := field.Tag.Get("env")
+		if env == "" {
+			return
+		}
+		raw, ok := os.LookupEnv(env)
+		if !ok || raw == "" {
+			return
+		}
+		if err := set(value, raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", env, err))
+		}
+	})
+	walk(cfg, func(path string, _ reflect.StructField, value reflect.Value) {
+		raw, ok := opts.overrides[path]
+		if !ok {
+			return
+		}
+		if err := set(value, raw); err != nil {
+			errs = append(errs, fmt.Errorf("--%s: %w", path, err))
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// decodeYAML разбирает YAML файл поверх cfg; неизвестные ключи - ошибка
+func decodeYAML(data []byte, cfg *Config) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// decodeTOML разбирает TOML файл поверх cfg. Ключи те же, что в YAML;
+// неизвестные ключи, как и в YAML, - ошибка. Длительности задаются
+// строкой ("30s") или числом секунд.
+func decodeTOML(data []byte, cfg *Config) error {
+	var tree map[string]any
+	if err := toml.Unmarshal(data, &tree); err != nil {
+		return err
+	}
+	return applyTree(reflect.ValueOf(cfg).Elem(), "", tree)
+}
+
+// applyTree записывает значения таблицы TOML в поля структуры v
+func applyTree(v reflect.Value, prefix string, tree map[string]any) error {
+	fields := make(map[string]int, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		fields[yamlName(v.Type().Field(i))] = i
+	}
+
+	var errs []error
+	for _, key := range slices.Sorted(maps.Keys(tree)) {
+		path := prefix + key
+		i, ok := fields[key]
+		if !ok {
+			errs = append(errs, fmt.Errorf("неизвестный параметр %s", path))
+			continue
+		}
+		value := v.Field(i)
+		if value.Kind() == reflect.Struct {
+			table, ok := tree[key].(map[string]any)
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s: ожидается таблица [%s]", path, path))
+				continue
+			}
+			if err := applyTree(value, path+".", table); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if err := setTOML(value, tree[key]); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// setTOML записывает в поле значение TOML. Массивы и таблицы допустимы
+// только для списков и заголовков, остальное разбирается как строка в set.
+func setTOML(value reflect.Value, raw any) error {
+	switch raw := raw.(type) {
+	case []any:
+		if value.Kind() != reflect.Slice {
+			return fmt.Errorf("ожидается одно значение, получен массив")
+		}
+		items := make([]string, 0, len(raw))
+		for _, item := range raw {
+			s, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("ожидается массив строк, получен элемент %v", item)
+			}
+			items = append(items, s)
+		}
+		value.Set(reflect.ValueOf(items))
+	case map[string]any:
+		if value.Kind() != reflect.Map {
+			return fmt.Errorf("ожидается одно значение, получена таблица")
+		}
+		headers := make(map[string]string, len(raw))
+		for name, item := range raw {
+			s, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("ожидается строка в %s, получено %v", name, item)
+			}
+			headers[name] = s
+		}
+		value.Set(reflect.ValueOf(headers))
+	case string, int64, bool:
+		if value.Kind() == reflect.Slice || value.Kind() == reflect.Map {
+			return fmt.Errorf("ожидается массив или таблица, получено %v", raw)
+		}
+		return set(value, fmt.Sprint(raw))
+	default:
+		return fmt.Errorf("неподдерживаемое значение %v", raw)
+	}
+	return nil
+}
+
+// Print выводит конфигурацию в YAML, заменяя секреты на "***"
+func (c *Config) Print(w io.Writer) error {
+	masked := *c
+	walk(&masked, func(_ string, field reflect.StructField, value reflect.Value) {
+		if field.Tag.Get("secret") != "true" {
+			return
+		}
+		switch value.Kind() {
+		case reflect.String:
+			if value.String() != "" {
+				value.SetString("***")
+			}
+		case reflect.Map:
+			// карта общая с c, поэтому заменяется целиком
+			headers := make(map[string]string, value.Len())
+			for _, key := range value.MapKeys() {
+				headers[key.String()] = "***"
+			}
+			value.Set(reflect.ValueOf(headers))
+		}
+	})
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(toYAML(reflect.ValueOf(masked))); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// toYAML превращает структуру в дерево для вывода: длительности
+// печатаются как "30s", а не числом наносекунд
+func toYAML(v reflect.Value) any {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	if v.Kind() != reflect.Struct {
+		return v.Interface()
+	}
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for i := 0; i < v.NumField(); i++ {
+		var value yaml.Node
+		if err := value.Encode(toYAML(v.Field(i))); err != nil {
+			continue
+		}
+		node.Content = append(node.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: yamlName(v.Type().Field(i))}, &value)
+	}
+	return node
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// walk вызывает fn для каждого конечного поля конфигурации с его путем в YAML
+func walk(cfg *Config, fn func(path string, field reflect.StructField, value reflect.Value)) {
+	var visit func(prefix string, v reflect.Value)
+	visit = func(prefix string, v reflect.Value) {
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			path := prefix + yamlName(field)
+			value := v.Field(i)
+			if value.Kind() == reflect.Struct {
+				visit(path+".", value)
+				continue
+			}
+			fn(path, field, value)
+		}
+	}
+	visit("", reflect.ValueOf(cfg).Elem())
+}
+
+// fieldPaths - пути всех параметров, доступных как флаги
+func fieldPaths() map[string]bool {
+	paths := map[string]bool{}
+	walk(Default(), func(path string, _ reflect.StructField, _ reflect.Value) {
+		paths[path] = true
+	})
+	return paths
+}
+
+func yamlName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+// set записывает в поле строковое значение. Длительность задается числом
+// секунд, как в прежних переменных окружения (JOB_TIMEOUT=600), или
+// строкой вида "30s", "2m".
+func set(value reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+	switch {
+	case value.Type() == durationType:
+		if seconds, err := strconv.Atoi(raw); err == nil {
+			value.SetInt(int64(time.Duration(seconds) * time.Second))
+			return nil
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("ожидается длительность (30s, 2m) или число секунд, получено %q", raw)
+		}
+		value.SetInt(int64(d))
+	case value.Kind() == reflect.String:
+		value.SetString(raw)
+	case value.Kind() == reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("ожидается целое число, получено %q", raw)
+		}
+		value.SetInt(int64(n))
+	case value.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("ожидается true или false, получено %q", raw)
+		}
+		value.SetBool(b)
+	case value.Kind() == reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		value.Set(reflect.ValueOf(items))
+	case value.Kind() == reflect.Map:
+		value.Set(reflect.ValueOf(ParseHeaders(raw)))
+	default:
+		return fmt.Errorf("неподдерживаемый тип %s", value.Type())
+	}
+	return nil
+}
+
+// ParseHeaders разбирает заголовки в формате "Name: value; Other: value"
+func ParseHeaders(raw string) map[string]string {
+	headers := map[string]string{}
+	for _, pair := range strings.Split(raw, ";") {
+		name, value, ok := strings.Cut(pair, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			continue
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return headers
+}
@@ -0,0 +1,284 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile - файл конфигурации во временном каталоге теста
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+	return path
+}
+
+// load разбирает флаги и собирает конфигурацию
+func load(t *testing.T, args ...string) (*Config, error) {
+	t.Helper()
+	opts, err := ParseFlags(args)
+	if err != nil {
+		t.Fatalf("ParseFlags(%q) error = %v", args, err)
+	}
+	return Load(opts)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	// Каждый параметр переопределен на одну ступень дальше предыдущего:
+	// model - только по умолчанию, baseURL - файлом, timeout - окружением,
+	// listen - флагом поверх файла и окружения.
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("OLLAMA_MODEL", "")
+	t.Setenv("OLLAMA_BASE_URL", "")
+	t.Setenv("OLLAMA_TIMEOUT", "300")
+	t.Setenv("LISTEN_ADDR", ":7070")
+
+	path := writeFile(t, "config.yaml", `
+server:
+  listen: ":6060"
+providers:
+  ollama:
+    baseURL: http://ollama:11434
+    timeout: 1m
+`)
+	cfg, err := load(t, "--config", path, "--server.listen=:9090")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{"default", cfg.Providers.Ollama.Model, "llama3.2"},
+		{"file over default", cfg.Providers.Ollama.BaseURL, "http://ollama:11434"},
+		{"env over file", cfg.Providers.Ollama.Timeout, 300 * time.Second},
+		{"flag over env and file", cfg.Server.Listen, ":9090"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigFileFromEnv(t *testing.T) {
+	path := writeFile(t, "config.yaml", "ai:\n  maxTokens: 2000\n")
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("AI_MAX_TOKENS", "")
+
+	cfg, err := load(t)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.AI.MaxTokens != 2000 {
+		t.Errorf("maxTokens = %d, want 2000 from CONFIG_FILE", cfg.AI.MaxTokens)
+	}
+}
+
+func TestLoadTOML(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	for _, env := range []string{"LISTEN_ADDR", "AI_PROVIDER", "AI_FALLBACK_PROVIDERS", "AI_RETRY_BUDGET_SECONDS",
+		"OLLAMA_TIMEOUT", "OPENAI_COMPAT_HEADERS", "OPENAI_COMPAT_JSON_MODE", "LANGFUSE_TIMEOUT"} {
+		t.Setenv(env, "")
+	}
+
+	yamlCfg, err := load(t, "--config", writeFile(t, "config.yaml", `
+server:
+  listen: ":9090"
+ai:
+  provider: ollama
+  fallback: ["openrouter", "gemini:gemini-2.0-flash"]
+  retryBudget: 45s
+providers:
+  ollama:
+    timeout: 5m
+  openaiCompatible:
+    jsonMode: false
+    headers:
+      X-Team: analytics
+langfuse:
+  timeout: 1m
+`))
+	if err != nil {
+		t.Fatalf("Load(yaml) error = %v", err)
+	}
+	tomlCfg, err := load(t, "--config", writeFile(t, "config.toml", `
+[server]
+listen = ":9090"
+
+[ai]
+provider = "ollama"
+fallback = ["openrouter", "gemini:gemini-2.0-flash"]
+retryBudget = "45s"
+
+[providers.ollama]
+timeout = "5m"
+
+[providers.openaiCompatible]
+jsonMode = false
+headers = { X-Team = "analytics" }
+
+[langfuse]
+timeout = 60 # число секунд, как в переменных окружения
+`))
+	if err != nil {
+		t.Fatalf("Load(toml) error = %v", err)
+	}
+
+	if !reflect.DeepEqual(tomlCfg, yamlCfg) {
+		t.Errorf("TOML config differs from the same YAML config:\n toml %+v\n yaml %+v", tomlCfg, yamlCfg)
+	}
+}
+
+func TestLoadExamples(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	for _, name := range []string{"config.example.yaml", "config.example.toml"} {
+		if _, err := load(t, "--config", filepath.Join("..", name)); err != nil {
+			t.Errorf("Load(%s) error = %v", name, err)
+		}
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    []string
+	}{
+		{
+			name:    "yaml top level",
+			file:    "config.yaml",
+			content: "sever:\n  listen: \":9090\"\n",
+			want:    []string{"sever"},
+		},
+		{
+			name:    "yaml nested",
+			file:    "config.yaml",
+			content: "providers:\n  ollama:\n    modle: llama3.1\n",
+			want:    []string{"modle"},
+		},
+		{
+			name:    "toml top level",
+			file:    "config.toml",
+			content: "[sever]\nlisten = \":9090\"\n",
+			want:    []string{"неизвестный параметр sever"},
+		},
+		{
+			name:    "toml nested, every key reported",
+			file:    "config.toml",
+			content: "[providers.ollama]\nmodle = \"llama3.1\"\n\n[ai]\nprovder = \"ollama\"\n",
+			want:    []string{"неизвестный параметр providers.ollama.modle", "неизвестный параметр ai.provder"},
+		},
+		{
+			name:    "toml value instead of table",
+			file:    "config.toml",
+			content: "server = \":9090\"\n",
+			want:    []string{"ожидается таблица [server]"},
+		},
+		{
+			name:    "toml wrong value type",
+			file:    "config.toml",
+			content: "[ai]\nmaxTokens = \"many\"\n",
+			want:    []string{"ai.maxTokens", "ожидается целое число"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, tt.file, tt.content)
+			_, err := load(t, "--config", path)
+			if err == nil {
+				t.Fatal("Load() error = nil, want unknown key rejected")
+			}
+			for _, want := range append(tt.want, path) {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error = %q, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseFlagsRejectsUnknown(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--server.lisen=:9090"}, "неизвестный флаг --server.lisen"},
+		{[]string{"--server.listen"}, "требует значение"},
+		{[]string{"serve"}, "неожиданный аргумент"},
+	}
+	for _, tt := range tests {
+		_, err := ParseFlags(tt.args)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseFlags(%q) error = %v, want %q", tt.args, err, tt.want)
+		}
+	}
+}
+
+func TestLoadRejectsBadOverrides(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("JOB_WORKERS", "")
+	t.Setenv("OLLAMA_TIMEOUT", "soon")
+
+	_, err := load(t, "--jobs.workers=many")
+	if err == nil {
+		t.Fatal("Load() error = nil, want invalid values rejected")
+	}
+	for _, want := range []string{"OLLAMA_TIMEOUT", "--jobs.workers"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %q, want it to contain %q", err, want)
+		}
+	}
+}
+
+func TestProviderTimeouts(t *testing.T) {
+	tests := []struct {
+		provider string
+		env      string
+		timeout  func(*Config) time.Duration
+	}{
+		{"anthropic", "ANTHROPIC_TIMEOUT", func(c *Config) time.Duration { return c.Providers.Anthropic.Timeout }},
+		{"gemini", "GEMINI_TIMEOUT", func(c *Config) time.Duration { return c.Providers.Gemini.Timeout }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			t.Setenv("CONFIG_FILE", "")
+			t.Setenv(tt.env, "")
+			cfg, err := load(t)
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if got := tt.timeout(cfg); got != 120*time.Second {
+				t.Errorf("default timeout = %s, want 2m0s", got)
+			}
+
+			t.Setenv(tt.env, "45")
+			if cfg, err = load(t); err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if got := tt.timeout(cfg); got != 45*time.Second {
+				t.Errorf("timeout from %s = %s, want 45s", tt.env, got)
+			}
+
+			if cfg, err = load(t, "--ai.provider="+tt.provider, "--providers."+tt.provider+".timeout=0"); err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			want := "providers." + tt.provider + ".timeout (" + tt.env + ") должен быть больше 0"
+			if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("Validate() error = %v, want %q", err, want)
+			}
+		})
+	}
+}
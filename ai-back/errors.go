@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+
+	"langfuse-analyzer-backend/apperr"
+	"langfuse-analyzer-backend/langfuse"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorResponse - тело ответа с ошибкой, одинаковое для всех эндпоинтов
+type ErrorResponse struct {
+	Code       apperr.Code `json:"code"`
+	Message    string      `json:"message"`
+	Error      string      `json:"error"` // то же, что message: поле, которое читают расширение и старые клиенты
+	RetryAfter int         `json:"retryAfter,omitempty"`
+	RequestID  string      `json:"requestId,omitempty"`
+	Details    any         `json:"details,omitempty"`
+	Status     int         `json:"status,omitempty"` // HTTP статус; только в событии error потока и во вложенных ошибках
+}
+
+// requestIDHeader - заголовок с ID запроса: берется из запроса клиента
+// или создается сервером и возвращается в ответе
+const requestIDHeader = "X-Request-ID"
+
+const requestIDKey = "requestId"
+
+// requestID назначает запросу ID, по которому ошибку в ответе можно найти в логах
+func requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = newRequestID()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// errorResponse возвращает HTTP статус и тело ответа для ошибки
+func errorResponse(c *gin.Context, err error) (int, *ErrorResponse) {
+	appErr := classifyError(err)
+	if errors.Is(context.Cause(c.Request.Context()), errShuttingDown) {
+		// Анализ прерван остановкой сервера, а не ошибкой провайдера
+		appErr = &apperr.Error{
+			Code:       apperr.CodeShuttingDown,
+			Message:    "Сервер останавливается, анализ прерван. Повторите запрос позже.",
+			RetryAfter: 10,
+			Err:        err,
+		}
+	}
+	id := c.GetString(requestIDKey)
+	log.Printf("⚠️  [%s] %s: %v", id, appErr.Code, err)
+
+	return appErr.Code.HTTPStatus(), newErrorResponse(appErr, id)
+}
+
+// nestedError - тело ошибки, вложенное в успешный ответ (задача, элемент
+// пакета): тот же формат, плюс HTTP статус, который вернул бы синхронный
+// запрос. requestID - ID запроса, в котором ошибка произошла и записана
+// в лог: для задачи это POST /jobs, а не запрос ее статуса.
+func nestedError(err error, requestID string) *ErrorResponse {
+	appErr := classifyError(err)
+	body := newErrorResponse(appErr, requestID)
+	body.Status = appErr.Code.HTTPStatus()
+	return body
+}
+
+// newErrorResponse формирует тело ответа для ошибки таксономии
+func newErrorResponse(appErr *apperr.Error, requestID string) *ErrorResponse {
+	return &ErrorResponse{
+		Code:       appErr.Code,
+		Message:    appErr.Message,
+		Error:      appErr.Message,
+		RetryAfter: appErr.RetryAfter,
+		RequestID:  requestID,
+		Details:    appErr.Details,
+	}
+}
+
+// invalidRequest - ошибка в теле или параметрах запроса клиента
+func invalidRequest(message string) *apperr.Error {
+	return apperr.New(apperr.CodeInvalidRequest, message)
+}
+
+// classifyError относит ошибку к таксономии API. Langfuse отвечает 404
+// и на трейсы, и на сессии, поэтому ненайденная сессия уточняется здесь.
+func classifyError(err error) *apperr.Error {
+	var fetchErr *fetchError
+	if errors.As(err, &fetchErr) && fetchErr.resource == "session" && errors.Is(err, langfuse.ErrNotFound) {
+		return apperr.Wrap(apperr.CodeSessionNotFound, "Сессия не найдена в Langfuse", err)
+	}
+	return apperr.From(err)
+}
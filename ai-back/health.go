@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"langfuse-analyzer-backend/ai"
+	"langfuse-analyzer-backend/apperr"
+	"langfuse-analyzer-backend/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readinessTimeout - сколько ждать ответа каждой зависимости в /readyz
+const readinessTimeout = 5 * time.Second
+
+// Состояния зависимости в /readyz
+const (
+	dependencyOK      = "ok"
+	dependencyError   = "error"
+	dependencyUnknown = "unknown" // клиент не умеет проверять зависимость
+)
+
+// DependencyStatus - результат проверки одной зависимости
+type DependencyStatus struct {
+	Name      string      `json:"name"` // "langfuse" или "provider/model"
+	Kind      string      `json:"kind"` // "langfuse" или "ai"
+	Status    string      `json:"status"`
+	LatencyMs int64       `json:"latencyMs"`
+	Code      apperr.Code `json:"code,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// ReadinessResponse - ответ /readyz
+type ReadinessResponse struct {
+	Status string             `json:"status"` // ready или not_ready
+	Checks []DependencyStatus `json:"checks"`
+}
+
+// handleHealthz - проверка живости: процесс запущен и обрабатывает
+// запросы. Внешние сервисы не проверяются, для этого есть /readyz.
+func handleHealthz(c *gin.Context) {
+	names := make([]string, len(aiProviders))
+	for i, p := range aiProviders {
+		names[i] = p.Name()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "ok",
+		"providers": names,
+	})
+}
+
+// handleReadyz - проверка готовности: Langfuse доступен и принимает ключи,
+// хотя бы один AI провайдер цепочки доступен и знает настроенную модель.
+// Зависимости проверяются параллельно, каждая не дольше readinessTimeout.
+// Если сервис не готов, возвращается 503 с тем же телом.
+func handleReadyz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := make([]DependencyStatus, 1+len(aiProviders))
+	var wg sync.WaitGroup
+	run := func(i int, name, kind string, check func(context.Context) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checks[i] = checkDependency(ctx, name, kind, check)
+		}()
+	}
+
+	run(0, "langfuse", "langfuse", func(ctx context.Context) error {
+		checker, ok := langfuseClient.(interface{ Check(context.Context) error })
+		if !ok {
+			return ai.ErrCheckUnsupported
+		}
+		return checker.Check(ctx)
+	})
+	for i, p := range aiProviders {
+		run(i+1, p.Name(), "ai", func(ctx context.Context) error {
+			return ai.Check(ctx, p.Client)
+		})
+	}
+	wg.Wait()
+
+	ready := checks[0].Status != dependencyError
+	providerReady := len(aiProviders) == 0
+	for _, check := range checks[1:] {
+		if check.Status != dependencyError {
+			providerReady = true
+		}
+	}
+
+	resp := ReadinessResponse{Status: "ready", Checks: checks}
+	status := http.StatusOK
+	if !ready || !providerReady {
+		resp.Status = "not_ready"
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, resp)
+}
+
+// checkDependency выполняет проверку и замеряет ее время
+func checkDependency(ctx context.Context, name, kind string, check func(context.Context) error) DependencyStatus {
+	started := time.Now()
+	err := check(ctx)
+	result := DependencyStatus{
+		Name:      name,
+		Kind:      kind,
+		Status:    dependencyOK,
+		LatencyMs: time.Since(started).Milliseconds(),
+	}
+	switch {
+	case errors.Is(err, ai.ErrCheckUnsupported):
+		result.Status = dependencyUnknown
+	case err != nil:
+		result.Status = dependencyError
+		result.Code = apperr.From(err).Code
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// observeRequests учитывает каждый HTTP запрос в метриках /metrics.
+// Запросы к неизвестным путям попадают в один ряд route="unmatched".
+func observeRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		started := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.ObserveHTTP(route, c.Request.Method, c.Writer.Status(), time.Since(started))
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"langfuse-analyzer-backend/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestObserveRequestsUsesRouteTemplate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(observeRequests())
+	router.GET("/observe-test/:id", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	for _, path := range []string{"/observe-test/job-1", "/observe-test/job-2", "/observe-test-missing/job-3"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+
+	want := `analyzer_http_requests_total{method="GET",route="/observe-test/:id",status="204"} 2`
+	if !strings.Contains(body, want) {
+		t.Errorf("metrics do not contain %s", want)
+	}
+	if !strings.Contains(body, `route="unmatched",status="404"`) {
+		t.Error("request to an unknown path is not counted as route=\"unmatched\"")
+	}
+	if strings.Contains(body, "job-") {
+		t.Error("metrics contain a raw request path instead of the route template")
+	}
+}
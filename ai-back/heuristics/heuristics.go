@@ -0,0 +1,353 @@
+package heuristics
+
+import (
+	"sort"
+	"time"
+
+	"langfuse-analyzer-backend/langfuse"
+)
+
+// Типы аномалий, которые можно вычислить по трейсу без LLM.
+// Совпадают со значениями anomalyType в ответе модели.
+const (
+	TypeError                 = "ERROR"
+	TypePerformanceBottleneck = "PERFORMANCE_BOTTLENECK"
+	TypeHighCost              = "HIGH_COST"
+	TypeLogicalLoop           = "LOGICAL_LOOP"
+)
+
+// Уровни серьезности находки
+const (
+	SeverityHigh   = "HIGH"
+	SeverityMedium = "MEDIUM"
+	SeverityLow    = "LOW"
+)
+
+// Finding - проверенный факт о трейсе
+type Finding struct {
+	Type             string   `json:"type"`
+	Severity         string   `json:"severity"`
+	TraceID          string   `json:"traceId,omitempty"`
+	ObservationIDs   []string `json:"observationIds,omitempty"`
+	ObservationNames []string `json:"observationNames,omitempty"`
+	Code             string   `json:"code"`    // правило, по которому найден факт (Code*)
+	Message          string   `json:"message"` // описание на языке отчета
+	Evidence         Evidence `json:"evidence"`
+
+	args []any // аргументы шаблона сообщения Code
+}
+
+// Evidence - числовые данные, на которых основана находка
+type Evidence struct {
+	Latency      float64 `json:"latency,omitempty"` // секунды
+	Share        float64 `json:"share,omitempty"`   // доля от общей задержки или стоимости, 0..1
+	Cost         float64 `json:"cost,omitempty"`    // USD
+	Tokens       int     `json:"tokens,omitempty"`
+	Count        int     `json:"count,omitempty"`
+	Threshold    float64 `json:"threshold,omitempty"`
+	ErrorMessage string  `json:"errorMessage,omitempty"`
+}
+
+// Thresholds - пороги срабатывания эвристик
+type Thresholds struct {
+	MaxTraceLatency   time.Duration // PERFORMANCE_BOTTLENECK: общая задержка трейса
+	MaxLatencyShare   float64       // PERFORMANCE_BOTTLENECK: доля одной операции в общей задержке
+	MaxTraceCost      float64       // HIGH_COST: стоимость трейса, USD
+	MaxTokens         int           // HIGH_COST: токенов в одной генерации
+	CostOutlierFactor float64       // HIGH_COST: во сколько раз генерация дороже медианной
+	MaxRepeats        int           // LOGICAL_LOOP: повторов одной операции с тем же input
+}
+
+// DefaultThresholds возвращает пороги, совпадающие с критериями в системном промпте
+func DefaultThresholds() Thresholds {
+	return Thresholds{
+		MaxTraceLatency:   10 * time.Second,
+		MaxLatencyShare:   0.7,
+		MaxTraceCost:      0.20,
+		MaxTokens:         5000,
+		CostOutlierFactor: 5,
+		MaxRepeats:        3,
+	}
+}
+
+// Analyze прогоняет все эвристики по трейсу и возвращает найденные факты,
+// отсортированные по серьезности. Сообщения пишутся на языке отчета
+// language; для языков без своих текстов - на английском.
+func Analyze(trace *langfuse.Trace, th Thresholds, language string) []Finding {
+	var findings []Finding
+	findings = append(findings, detectErrors(trace)...)
+	findings = append(findings, detectBottlenecks(trace, th)...)
+	findings = append(findings, detectHighCost(trace, th)...)
+	findings = append(findings, detectLoops(trace, th)...)
+
+	for i := range findings {
+		findings[i].TraceID = trace.ID
+		findings[i].Message = message(language, findings[i].Code, findings[i].args)
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return severityRank(findings[i].Severity) < severityRank(findings[j].Severity)
+	})
+	return findings
+}
+
+func severityRank(severity string) int {
+	switch severity {
+	case SeverityHigh:
+		return 0
+	case SeverityMedium:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// detectErrors находит наблюдения с level: ERROR
+func detectErrors(trace *langfuse.Trace) []Finding {
+	var findings []Finding
+	for _, o := range trace.Observations {
+		if !o.IsError() {
+			continue
+		}
+		findings = append(findings, Finding{
+			Type:             TypeError,
+			Severity:         SeverityHigh,
+			ObservationIDs:   []string{o.ID},
+			ObservationNames: []string{o.Name},
+			Code:             CodeObservationError,
+			args:             []any{o.Name, o.Type},
+			Evidence: Evidence{
+				Latency:      o.Duration().Seconds(),
+				ErrorMessage: o.StatusMessage,
+			},
+		})
+	}
+	return findings
+}
+
+// detectBottlenecks проверяет общую задержку трейса и долю самой медленной
+// листовой операции. Родительские span'ы не учитываются: они по определению
+// покрывают время своих дочерних наблюдений.
+func detectBottlenecks(trace *langfuse.Trace, th Thresholds) []Finding {
+	var findings []Finding
+
+	total := traceLatency(trace)
+	if th.MaxTraceLatency > 0 && total > th.MaxTraceLatency {
+		findings = append(findings, Finding{
+			Type:     TypePerformanceBottleneck,
+			Severity: SeverityMedium,
+			Code:     CodeSlowTrace,
+			args:     []any{total.Seconds(), th.MaxTraceLatency.Seconds()},
+			Evidence: Evidence{
+				Latency:   total.Seconds(),
+				Threshold: th.MaxTraceLatency.Seconds(),
+			},
+		})
+	}
+
+	if total <= 0 || th.MaxLatencyShare <= 0 {
+		return findings
+	}
+
+	parents := make(map[string]bool)
+	for _, o := range trace.Observations {
+		if o.ParentObservationID != "" {
+			parents[o.ParentObservationID] = true
+		}
+	}
+
+	var slowest *langfuse.Observation
+	for i, o := range trace.Observations {
+		if parents[o.ID] {
+			continue
+		}
+		if slowest == nil || o.Duration() > slowest.Duration() {
+			slowest = &trace.Observations[i]
+		}
+	}
+	if slowest == nil {
+		return findings
+	}
+
+	share := slowest.Duration().Seconds() / total.Seconds()
+	if share > th.MaxLatencyShare {
+		findings = append(findings, Finding{
+			Type:             TypePerformanceBottleneck,
+			Severity:         SeverityMedium,
+			ObservationIDs:   []string{slowest.ID},
+			ObservationNames: []string{slowest.Name},
+			Code:             CodeSlowObservation,
+			args:             []any{slowest.Name, share * 100, slowest.Duration().Seconds(), total.Seconds()},
+			Evidence: Evidence{
+				Latency:   slowest.Duration().Seconds(),
+				Share:     share,
+				Threshold: th.MaxLatencyShare,
+			},
+		})
+	}
+	return findings
+}
+
+// detectHighCost проверяет стоимость трейса, число токенов в генерациях
+// и генерации, которые намного дороже остальных
+func detectHighCost(trace *langfuse.Trace, th Thresholds) []Finding {
+	var findings []Finding
+
+	totalCost := trace.TotalCost
+	if totalCost == 0 {
+		for i := range trace.Observations {
+			totalCost += trace.Observations[i].Cost()
+		}
+	}
+	if th.MaxTraceCost > 0 && totalCost > th.MaxTraceCost {
+		findings = append(findings, Finding{
+			Type:     TypeHighCost,
+			Severity: SeverityMedium,
+			Code:     CodeTraceCost,
+			args:     []any{totalCost, th.MaxTraceCost},
+			Evidence: Evidence{
+				Cost:      totalCost,
+				Threshold: th.MaxTraceCost,
+			},
+		})
+	}
+
+	var costs []float64
+	for i := range trace.Observations {
+		o := &trace.Observations[i]
+		if o.Type != langfuse.ObservationGeneration {
+			continue
+		}
+		if c := o.Cost(); c > 0 {
+			costs = append(costs, c)
+		}
+
+		if tokens := o.TotalTokens(); th.MaxTokens > 0 && tokens > th.MaxTokens {
+			findings = append(findings, Finding{
+				Type:             TypeHighCost,
+				Severity:         SeverityMedium,
+				ObservationIDs:   []string{o.ID},
+				ObservationNames: []string{o.Name},
+				Code:             CodeGenerationTokens,
+				args:             []any{o.Name, tokens, th.MaxTokens},
+				Evidence: Evidence{
+					Tokens:    tokens,
+					Cost:      o.Cost(),
+					Threshold: float64(th.MaxTokens),
+				},
+			})
+		}
+	}
+
+	// Выбросы имеют смысл только при нескольких платных генерациях
+	if th.CostOutlierFactor <= 0 || len(costs) < 3 {
+		return findings
+	}
+	median := medianOf(costs)
+	for i := range trace.Observations {
+		o := &trace.Observations[i]
+		if o.Type != langfuse.ObservationGeneration || median <= 0 {
+			continue
+		}
+		if c := o.Cost(); c > median*th.CostOutlierFactor {
+			share := 0.0
+			if totalCost > 0 {
+				share = c / totalCost
+			}
+			findings = append(findings, Finding{
+				Type:             TypeHighCost,
+				Severity:         SeverityLow,
+				ObservationIDs:   []string{o.ID},
+				ObservationNames: []string{o.Name},
+				Code:             CodeCostOutlier,
+				args:             []any{o.Name, c, c / median, median},
+				Evidence: Evidence{
+					Cost:      c,
+					Share:     share,
+					Threshold: th.CostOutlierFactor,
+				},
+			})
+		}
+	}
+	return findings
+}
+
+// detectLoops находит операции, которые многократно вызывались с одинаковым input
+func detectLoops(trace *langfuse.Trace, th Thresholds) []Finding {
+	if th.MaxRepeats <= 0 {
+		return nil
+	}
+
+	type group struct {
+		name string
+		ids  []string
+	}
+	groups := make(map[string]*group)
+	var order []string
+	for _, o := range trace.Observations {
+		if o.Input == nil || o.Type == langfuse.ObservationEvent {
+			continue
+		}
+		key := o.Name + "|" + langfuse.HashValue(o.Input)
+		g, ok := groups[key]
+		if !ok {
+			g = &group{name: o.Name}
+			groups[key] = g
+			order = append(order, key)
+		}
+		g.ids = append(g.ids, o.ID)
+	}
+
+	var findings []Finding
+	for _, key := range order {
+		g := groups[key]
+		if len(g.ids) <= th.MaxRepeats {
+			continue
+		}
+		findings = append(findings, Finding{
+			Type:             TypeLogicalLoop,
+			Severity:         SeverityMedium,
+			ObservationIDs:   g.ids,
+			ObservationNames: []string{g.name},
+			Code:             CodeRepeatedInput,
+			args:             []any{g.name, len(g.ids)},
+			Evidence: Evidence{
+				Count:     len(g.ids),
+				Threshold: float64(th.MaxRepeats),
+			},
+		})
+	}
+	return findings
+}
+
+// traceLatency возвращает задержку трейса; если Langfuse ее не прислал,
+// вычисляет по самому раннему началу и самому позднему концу наблюдений
+func traceLatency(trace *langfuse.Trace) time.Duration {
+	if trace.Latency > 0 {
+		return time.Duration(trace.Latency * float64(time.Second))
+	}
+
+	var start, end time.Time
+	for _, o := range trace.Observations {
+		if !o.StartTime.IsZero() && (start.IsZero() || o.StartTime.Before(start)) {
+			start = o.StartTime
+		}
+		if o.EndTime != nil && o.EndTime.After(end) {
+			end = *o.EndTime
+		}
+	}
+	if start.IsZero() || end.IsZero() {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+func medianOf(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
@@ -0,0 +1,122 @@
+package heuristics
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"langfuse-analyzer-backend/langfuse"
+)
+
+// testTrace - трейс, в котором срабатывает каждая эвристика
+func testTrace() *langfuse.Trace {
+	trace := &langfuse.Trace{ID: "trace-1", Latency: 20, TotalCost: 0.5}
+	add := func(o langfuse.Observation) {
+		trace.Observations = append(trace.Observations, o)
+	}
+	add(langfuse.Observation{ID: "err", Name: "search", Type: langfuse.ObservationSpan, Level: langfuse.LevelError, Latency: 1})
+	add(langfuse.Observation{ID: "slow", Name: "vector_search", Type: langfuse.ObservationSpan, Latency: 18})
+	add(langfuse.Observation{ID: "big", Name: "summarize", Type: langfuse.ObservationGeneration,
+		CalculatedTotalCost: 0.4, Usage: &langfuse.Usage{Total: 8000}})
+	for i := 0; i < 3; i++ {
+		add(langfuse.Observation{ID: fmt.Sprintf("gen-%d", i), Name: "classify", Type: langfuse.ObservationGeneration,
+			CalculatedTotalCost: 0.01, Input: fmt.Sprintf("question %d", i)})
+	}
+	for i := 0; i < 4; i++ {
+		add(langfuse.Observation{ID: fmt.Sprintf("loop-%d", i), Name: "lookup", Type: langfuse.ObservationSpan,
+			Input: map[string]any{"query": "same"}})
+	}
+	return trace
+}
+
+func TestAnalyzeCodes(t *testing.T) {
+	findings := Analyze(testTrace(), DefaultThresholds(), "en")
+
+	got := make(map[string]Finding)
+	for _, f := range findings {
+		if _, dup := got[f.Code]; dup {
+			t.Errorf("code %s found twice", f.Code)
+		}
+		got[f.Code] = f
+		if f.TraceID != "trace-1" || f.Message == "" {
+			t.Errorf("finding %+v: want traceId and message", f)
+		}
+	}
+
+	want := map[string]struct {
+		typ string
+		ids []string
+	}{
+		CodeObservationError: {TypeError, []string{"err"}},
+		CodeSlowTrace:        {TypePerformanceBottleneck, nil},
+		CodeSlowObservation:  {TypePerformanceBottleneck, []string{"slow"}},
+		CodeTraceCost:        {TypeHighCost, nil},
+		CodeGenerationTokens: {TypeHighCost, []string{"big"}},
+		CodeCostOutlier:      {TypeHighCost, []string{"big"}},
+		CodeRepeatedInput:    {TypeLogicalLoop, []string{"loop-0", "loop-1", "loop-2", "loop-3"}},
+	}
+	if len(got) != len(want) {
+		t.Errorf("codes = %d, want %d", len(got), len(want))
+	}
+	for code, w := range want {
+		f, ok := got[code]
+		if !ok {
+			t.Errorf("no finding with code %s", code)
+			continue
+		}
+		if f.Type != w.typ || fmt.Sprint(f.ObservationIDs) != fmt.Sprint(w.ids) {
+			t.Errorf("%s: type %s, ids %v; want %s, %v", code, f.Type, f.ObservationIDs, w.typ, w.ids)
+		}
+	}
+	if findings[0].Severity != SeverityHigh {
+		t.Errorf("first finding severity = %s, want findings sorted by severity", findings[0].Severity)
+	}
+}
+
+func TestAnalyzeMessageLanguage(t *testing.T) {
+	tests := []struct {
+		language string
+		want     string
+	}{
+		{"ru", `Наблюдение "search" (SPAN) завершилось с level ERROR`},
+		{"en", `Observation "search" (SPAN) ended with level ERROR`},
+		{"de", `Observation "search" (SPAN) ended with level ERROR`}, // своих текстов нет
+	}
+	for _, tt := range tests {
+		t.Run(tt.language, func(t *testing.T) {
+			findings := Analyze(testTrace(), DefaultThresholds(), tt.language)
+			if findings[0].Code != CodeObservationError || findings[0].Message != tt.want {
+				t.Errorf("finding = %s %q, want %s %q", findings[0].Code, findings[0].Message, CodeObservationError, tt.want)
+			}
+			for _, f := range findings {
+				if f.Message == "" || f.Message != message(tt.language, f.Code, f.args) {
+					t.Errorf("%s: message %q not rendered", f.Code, f.Message)
+				}
+			}
+		})
+	}
+}
+
+func TestMessagesComplete(t *testing.T) {
+	codes := []string{CodeObservationError, CodeSlowTrace, CodeSlowObservation, CodeTraceCost,
+		CodeGenerationTokens, CodeCostOutlier, CodeRepeatedInput}
+	for language, texts := range messages {
+		if len(texts) != len(codes) {
+			t.Errorf("%s: %d messages, want %d", language, len(texts), len(codes))
+		}
+		for _, code := range codes {
+			if texts[code] == "" {
+				t.Errorf("%s: no message for %s", language, code)
+			}
+		}
+	}
+
+	// Аргументы шаблонов одинаковы во всех языках: без %!(EXTRA ...) и %!s(MISSING)
+	for _, f := range Analyze(testTrace(), DefaultThresholds(), "ru") {
+		for language := range messages {
+			if msg := message(language, f.Code, f.args); strings.Contains(msg, "%!") {
+				t.Errorf("%s %s: bad message %q", language, f.Code, msg)
+			}
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package heuristics
+
+import "fmt"
+
+// Коды находок - стабильные идентификаторы правил. В отличие от message,
+// не зависят от языка отчета: по ним клиент может отличать находки одного
+// типа (например, HIGH_COST по стоимости трейса и по токенам генерации).
+const (
+	CodeObservationError = "OBSERVATION_ERROR" // наблюдение с level ERROR
+	CodeSlowTrace        = "SLOW_TRACE"        // общая задержка трейса выше порога
+	CodeSlowObservation  = "SLOW_OBSERVATION"  // операция занимает большую часть задержки
+	CodeTraceCost        = "TRACE_COST"        // стоимость трейса выше порога
+	CodeGenerationTokens = "GENERATION_TOKENS" // генерация с числом токенов выше порога
+	CodeCostOutlier      = "COST_OUTLIER"      // генерация намного дороже медианной
+	CodeRepeatedInput    = "REPEATED_INPUT"    // операция повторяется с тем же input
+)
+
+// fallbackLanguage - язык сообщений для языков без собственных текстов.
+// Сообщения передаются модели как факты, и она пересказывает их на языке
+// отчета, как и промпты на английском.
+const fallbackLanguage = "en"
+
+// messages - шаблоны сообщений по языку и коду находки. Аргументы шаблона
+// одинаковы во всех языках и задаются при создании находки.
+var messages = map[string]map[string]string{
+	"ru": {
+		CodeObservationError: "Наблюдение %q (%s) завершилось с level ERROR",
+		CodeSlowTrace:        "Общая задержка трейса %.1fs превышает порог %.0fs",
+		CodeSlowObservation:  "Наблюдение %q занимает %.0f%% общей задержки трейса (%.1fs из %.1fs)",
+		CodeTraceCost:        "Стоимость трейса $%.4f превышает порог $%.2f",
+		CodeGenerationTokens: "Генерация %q использовала %d токенов (порог %d)",
+		CodeCostOutlier:      "Генерация %q стоит $%.4f — в %.1f раз дороже медианной генерации трейса ($%.4f)",
+		CodeRepeatedInput:    "Операция %q вызвана %d раз с одинаковым input",
+	},
+	"en": {
+		CodeObservationError: "Observation %q (%s) ended with level ERROR",
+		CodeSlowTrace:        "Total trace latency %.1fs exceeds the %.0fs threshold",
+		CodeSlowObservation:  "Observation %q takes %.0f%% of the total trace latency (%.1fs of %.1fs)",
+		CodeTraceCost:        "Trace cost $%.4f exceeds the $%.2f threshold",
+		CodeGenerationTokens: "Generation %q used %d tokens (threshold %d)",
+		CodeCostOutlier:      "Generation %q costs $%.4f — %.1f times more than the median generation in the trace ($%.4f)",
+		CodeRepeatedInput:    "Operation %q was called %d times with the same input",
+	},
+}
+
+// message возвращает текст находки на языке language
+func message(language, code string, args []any) string {
+	texts, ok := messages[language]
+	if !ok {
+		texts = messages[fallbackLanguage]
+	}
+	return fmt.Sprintf(texts[code], args...)
+}
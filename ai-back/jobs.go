@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"langfuse-analyzer-backend/apperr"
+	"langfuse-analyzer-backend/jobs"
+
+	"github.com/gin-gonic/gin"
+)
+
+var jobManager *jobs.Manager
+
+// handleCreateJob ставит анализ трейса в очередь и сразу возвращает ID задачи
+func handleCreateJob(c *gin.Context) {
+	var req AnalyzeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("❌ Ошибка парсинга JSON: %v", err)
+		c.JSON(errorResponse(c, invalidRequest("Некорректный JSON: "+err.Error())))
+		return
+	}
+	if req.TraceID == "" {
+		c.JSON(errorResponse(c, invalidRequest("Не указан traceId")))
+		return
+	}
+	language, err := resolveLanguage(req.Language)
+	if err != nil {
+		c.JSON(errorResponse(c, languageError(err)))
+		return
+	}
+
+	job, err := jobManager.Submit(c.GetString(requestIDKey), func(ctx context.Context) (interface{}, error) {
+		return runAnalysis(ctx, req.TraceID, language)
+	})
+	if errors.Is(err, jobs.ErrClosed) {
+		c.JSON(errorResponse(c, apperr.Wrap(apperr.CodeShuttingDown, "Сервер останавливается. Повторите запрос позже.", err)))
+		return
+	}
+	if err != nil {
+		log.Printf("⚠️  Не удалось поставить задачу в очередь: %v", err)
+		c.JSON(errorResponse(c, &apperr.Error{
+			Code:       apperr.CodeQueueFull,
+			Message:    "Очередь анализа переполнена. Попробуйте позже.",
+			RetryAfter: 10,
+			Err:        err,
+		}))
+		return
+	}
+
+	log.Printf("📥 Задача %s создана для traceId: %s", job.ID, req.TraceID)
+	c.Header("Location", "/jobs/"+job.ID)
+	c.JSON(http.StatusAccepted, jobResponse(job))
+}
+
+// handleGetJob возвращает статус и, если готово, результат задачи
+func handleGetJob(c *gin.Context) {
+	job, err := jobManager.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(errorResponse(c, apperr.Wrap(apperr.CodeJobNotFound, "Задача не найдена", err)))
+		return
+	}
+	c.JSON(http.StatusOK, jobResponse(job))
+}
+
+// handleCancelJob отменяет задачу
+func handleCancelJob(c *gin.Context) {
+	job, err := jobManager.Cancel(c.Param("id"))
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		c.JSON(errorResponse(c, apperr.Wrap(apperr.CodeJobNotFound, "Задача не найдена", err)))
+	case errors.Is(err, jobs.ErrFinished):
+		// Состояние задачи - в details, ее собственная ошибка не меняется
+		appErr := apperr.Wrap(apperr.CodeJobFinished, "Задача уже завершена", err)
+		appErr.Details = jobResponse(job)
+		c.JSON(errorResponse(c, appErr))
+	default:
+		log.Printf("🛑 Задача %s отменена", job.ID)
+		c.JSON(http.StatusOK, jobResponse(job))
+	}
+}
+
+// JobResponse - JSON представление задачи
+type JobResponse struct {
+	JobID      string      `json:"jobId"`
+	Status     jobs.Status `json:"status"`
+	CreatedAt  time.Time   `json:"createdAt"`
+	StartedAt  *time.Time  `json:"startedAt,omitempty"`
+	FinishedAt *time.Time  `json:"finishedAt,omitempty"`
+
+	// Результат для status = succeeded
+	*AnalysisResponse
+
+	// Ошибка для status = failed в том же формате, что и ответ с ошибкой
+	// синхронного /analyze
+	Error *ErrorResponse `json:"error,omitempty"`
+}
+
+// jobResponse формирует JSON представление задачи
+func jobResponse(job jobs.Job) *JobResponse {
+	resp := &JobResponse{
+		JobID:      job.ID,
+		Status:     job.Status,
+		CreatedAt:  job.CreatedAt,
+		StartedAt:  job.StartedAt,
+		FinishedAt: job.FinishedAt,
+	}
+
+	switch job.Status {
+	case jobs.StatusSucceeded:
+		resp.AnalysisResponse, _ = job.Result.(*AnalysisResponse)
+	case jobs.StatusFailed:
+		// requestId - запроса, создавшего задачу: под ним ошибка записана в лог
+		resp.Error = nestedError(job.Err, job.RequestID)
+	}
+	return resp
+}
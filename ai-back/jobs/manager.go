@@ -0,0 +1,289 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// Status - состояние задачи
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCanceled  Status = "canceled"
+)
+
+// Done сообщает, что задача завершена и больше не изменится
+func (s Status) Done() bool {
+	return s == StatusSucceeded || s == StatusFailed || s == StatusCanceled
+}
+
+var (
+	// ErrQueueFull возвращается, когда очередь задач переполнена
+	ErrQueueFull = errors.New("очередь задач переполнена")
+	// ErrNotFound возвращается для неизвестного ID задачи
+	ErrNotFound = errors.New("задача не найдена")
+	// ErrFinished возвращается при попытке отменить завершенную задачу
+	ErrFinished = errors.New("задача уже завершена")
+	// ErrClosed возвращается, когда менеджер останавливается и новые задачи не принимает
+	ErrClosed = errors.New("очередь задач остановлена")
+)
+
+// Func - работа, выполняемая задачей. Должна завершаться при отмене ctx.
+type Func func(ctx context.Context) (interface{}, error)
+
+// Job - снимок состояния задачи
+type Job struct {
+	ID         string
+	RequestID  string // ID HTTP запроса, создавшего задачу
+	Status     Status
+	CreatedAt  time.Time
+	StartedAt  *time.Time
+	FinishedAt *time.Time
+	Result     interface{}
+	Err        error
+}
+
+// Config - настройки менеджера задач
+type Config struct {
+	Workers   int           // число одновременно выполняемых задач
+	QueueSize int           // максимальное число задач в очереди
+	Timeout   time.Duration // максимальное время выполнения одной задачи (0 - без ограничения)
+	TTL       time.Duration // сколько хранить завершенные задачи
+}
+
+type entry struct {
+	job    Job
+	fn     Func
+	cancel context.CancelFunc
+	ctx    context.Context
+}
+
+// Manager - очередь задач с ограниченным пулом воркеров
+type Manager struct {
+	mu      sync.Mutex
+	jobs    map[string]*entry
+	queue   chan *entry
+	timeout time.Duration
+	ttl     time.Duration
+	closed  bool
+	workers sync.WaitGroup
+}
+
+// NewManager создает менеджер и запускает воркеры
+func NewManager(cfg Config) *Manager {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 2
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 100
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = time.Hour
+	}
+
+	m := &Manager{
+		jobs:    make(map[string]*entry),
+		queue:   make(chan *entry, cfg.QueueSize),
+		timeout: cfg.Timeout,
+		ttl:     cfg.TTL,
+	}
+	m.workers.Add(cfg.Workers)
+	for i := 0; i < cfg.Workers; i++ {
+		go m.worker()
+	}
+	return m
+}
+
+// Submit ставит задачу в очередь и сразу возвращает ее снимок. requestID -
+// ID запроса, создавшего задачу: с ним задача пишет в лог свое завершение.
+func (m *Manager) Submit(requestID string, fn Func) (Job, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &entry{
+		job: Job{
+			ID:        newID(),
+			RequestID: requestID,
+			Status:    StatusQueued,
+			CreatedAt: time.Now(),
+		},
+		fn:     fn,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		cancel()
+		return Job{}, ErrClosed
+	}
+	m.pruneLocked()
+
+	select {
+	case m.queue <- e:
+	default:
+		cancel()
+		return Job{}, ErrQueueFull
+	}
+	m.jobs[e.job.ID] = e
+	return e.job, nil
+}
+
+// Get возвращает снимок задачи
+func (m *Manager) Get(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return e.job, nil
+}
+
+// Cancel отменяет задачу. Задача в очереди завершается сразу, выполняющаяся -
+// после того как ее Func отреагирует на отмену контекста.
+func (m *Manager) Cancel(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	if e.job.Status.Done() {
+		return e.job, ErrFinished
+	}
+
+	e.cancel()
+	if e.job.Status == StatusQueued {
+		m.finishLocked(e, nil, context.Canceled)
+	}
+	return e.job, nil
+}
+
+// Shutdown останавливает менеджер: новые задачи не принимаются, задачи
+// в очереди отменяются, выполняющимся дается время завершиться до
+// отмены ctx. После отмены ctx выполняющиеся задачи отменяются, и
+// Shutdown дожидается, пока их Func отреагируют на отмену.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	if !m.closed {
+		m.closed = true
+		close(m.queue)
+		for _, e := range m.jobs {
+			if e.job.Status == StatusQueued {
+				e.cancel()
+				m.finishLocked(e, nil, context.Canceled)
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	m.mu.Lock()
+	for _, e := range m.jobs {
+		if e.job.Status == StatusRunning {
+			log.Printf("⚙️  Задача %s отменена при остановке сервера", e.job.ID)
+			e.cancel()
+		}
+	}
+	m.mu.Unlock()
+	<-done
+	return ctx.Err()
+}
+
+func (m *Manager) worker() {
+	defer m.workers.Done()
+	for e := range m.queue {
+		m.run(e)
+	}
+}
+
+func (m *Manager) run(e *entry) {
+	m.mu.Lock()
+	if e.job.Status != StatusQueued {
+		// Задача отменена, пока ждала в очереди
+		m.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	e.job.Status = StatusRunning
+	e.job.StartedAt = &now
+	m.mu.Unlock()
+
+	ctx := e.ctx
+	if m.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.timeout)
+		defer cancel()
+	}
+
+	log.Printf("⚙️  [%s] Задача %s запущена", e.job.RequestID, e.job.ID)
+	result, err := e.fn(ctx)
+
+	m.mu.Lock()
+	m.finishLocked(e, result, err)
+	m.mu.Unlock()
+	e.cancel()
+
+	if err != nil {
+		log.Printf("⚙️  [%s] Задача %s завершена со статусом %s: %v", e.job.RequestID, e.job.ID, e.job.Status, err)
+	} else {
+		log.Printf("⚙️  [%s] Задача %s завершена со статусом %s", e.job.RequestID, e.job.ID, e.job.Status)
+	}
+}
+
+func (m *Manager) finishLocked(e *entry, result interface{}, err error) {
+	now := time.Now()
+	e.job.FinishedAt = &now
+	e.job.Result = result
+	e.job.Err = err
+
+	switch {
+	case err == nil:
+		e.job.Status = StatusSucceeded
+	case errors.Is(e.ctx.Err(), context.Canceled):
+		e.job.Status = StatusCanceled
+	default:
+		e.job.Status = StatusFailed
+	}
+}
+
+// pruneLocked удаляет завершенные задачи старше TTL
+func (m *Manager) pruneLocked() {
+	cutoff := time.Now().Add(-m.ttl)
+	for id, e := range m.jobs {
+		if e.job.Status.Done() && e.job.FinishedAt.Before(cutoff) {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
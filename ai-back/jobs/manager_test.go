@@ -0,0 +1,249 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// blockingRunner - работа задачи, которая ждет release или отмены ctx
+type blockingRunner struct {
+	started  chan struct{}
+	release  chan struct{}
+	canceled chan error // ошибка ctx, с которой задача завершилась по отмене
+}
+
+func newBlockingRunner() *blockingRunner {
+	return &blockingRunner{
+		started:  make(chan struct{}, 16),
+		release:  make(chan struct{}),
+		canceled: make(chan error, 16),
+	}
+}
+
+func (r *blockingRunner) run(ctx context.Context) (interface{}, error) {
+	r.started <- struct{}{}
+	select {
+	case <-r.release:
+		return "ok", nil
+	case <-ctx.Done():
+		r.canceled <- ctx.Err()
+		return nil, ctx.Err()
+	}
+}
+
+// waitStarted ждет, пока воркеры запустят n задач
+func (r *blockingRunner) waitStarted(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.started:
+		case <-time.After(time.Second):
+			t.Fatalf("%d of %d jobs started", i, n)
+		}
+	}
+}
+
+// waitStatus ждет, пока задача перейдет в статус want
+func waitStatus(t *testing.T, m *Manager, id string, want Status) Job {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		job, err := m.Get(id)
+		if err != nil {
+			t.Fatalf("Get(%s) error = %v", id, err)
+		}
+		if job.Status == want {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s status = %s, want %s", id, job.Status, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// shutdown останавливает менеджер в конце теста, отпуская задачи
+func shutdown(t *testing.T, m *Manager, r *blockingRunner) {
+	t.Cleanup(func() {
+		select {
+		case <-r.release:
+		default:
+			close(r.release)
+		}
+		m.Shutdown(context.Background())
+	})
+}
+
+func TestSubmitQueueFull(t *testing.T) {
+	r := newBlockingRunner()
+	m := NewManager(Config{Workers: 2, QueueSize: 1})
+	shutdown(t, m, r)
+
+	for i := 0; i < 2; i++ {
+		if _, err := m.Submit("req-1", r.run); err != nil {
+			t.Fatalf("Submit() for worker %d error = %v", i, err)
+		}
+		r.waitStarted(t, 1)
+	}
+
+	queued, err := m.Submit("req-1", r.run)
+	if err != nil || queued.Status != StatusQueued {
+		t.Fatalf("Submit() into the queue = %+v, %v; want queued", queued, err)
+	}
+	if _, err := m.Submit("req-1", r.run); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Submit() with busy workers and full queue error = %v, want ErrQueueFull", err)
+	}
+
+	// Освободившийся воркер берет задачу из очереди, и в ней снова есть место
+	close(r.release)
+	waitStatus(t, m, queued.ID, StatusSucceeded)
+	if _, err := m.Submit("req-1", r.run); err != nil {
+		t.Errorf("Submit() after the queue drained error = %v", err)
+	}
+}
+
+func TestCancelQueuedJob(t *testing.T) {
+	r := newBlockingRunner()
+	m := NewManager(Config{Workers: 1, QueueSize: 2})
+	shutdown(t, m, r)
+
+	running, _ := m.Submit("req-1", r.run)
+	r.waitStarted(t, 1)
+	queued, _ := m.Submit("req-1", r.run)
+
+	job, err := m.Cancel(queued.ID)
+	if err != nil || job.Status != StatusCanceled || job.FinishedAt == nil {
+		t.Fatalf("Cancel(queued) = %+v, %v; want canceled at once", job, err)
+	}
+	if _, err := m.Cancel(queued.ID); !errors.Is(err, ErrFinished) {
+		t.Errorf("second Cancel() error = %v, want ErrFinished", err)
+	}
+
+	// Воркер пропускает отмененную задачу и не запускает ее
+	close(r.release)
+	waitStatus(t, m, running.ID, StatusSucceeded)
+	select {
+	case <-r.started:
+		t.Error("canceled queued job was started")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if job, _ := m.Get(queued.ID); job.Status != StatusCanceled || job.StartedAt != nil {
+		t.Errorf("queued job = %+v, want canceled and never started", job)
+	}
+}
+
+func TestCancelRunningJob(t *testing.T) {
+	r := newBlockingRunner()
+	m := NewManager(Config{Workers: 1, QueueSize: 1})
+	shutdown(t, m, r)
+
+	submitted, _ := m.Submit("req-1", r.run)
+	r.waitStarted(t, 1)
+	waitStatus(t, m, submitted.ID, StatusRunning)
+
+	if _, err := m.Cancel(submitted.ID); err != nil {
+		t.Fatalf("Cancel(running) error = %v", err)
+	}
+	select {
+	case err := <-r.canceled:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("job ctx error = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("running job ctx was not canceled")
+	}
+
+	job := waitStatus(t, m, submitted.ID, StatusCanceled)
+	if job.StartedAt == nil || job.FinishedAt == nil || !errors.Is(job.Err, context.Canceled) {
+		t.Errorf("job = %+v, want canceled with context.Canceled", job)
+	}
+}
+
+func TestJobTimeoutFails(t *testing.T) {
+	r := newBlockingRunner()
+	m := NewManager(Config{Workers: 1, QueueSize: 1, Timeout: 20 * time.Millisecond})
+	shutdown(t, m, r)
+
+	submitted, _ := m.Submit("req-1", r.run)
+	job := waitStatus(t, m, submitted.ID, StatusFailed)
+	if !errors.Is(job.Err, context.DeadlineExceeded) {
+		t.Errorf("job error = %v, want context.DeadlineExceeded: timeout is a failure, not a cancellation", job.Err)
+	}
+}
+
+func TestShutdownDrainsRunningJobs(t *testing.T) {
+	r := newBlockingRunner()
+	m := NewManager(Config{Workers: 1, QueueSize: 2})
+
+	running, _ := m.Submit("req-1", r.run)
+	r.waitStarted(t, 1)
+	queued, _ := m.Submit("req-1", r.run)
+
+	done := make(chan error, 1)
+	go func() { done <- m.Shutdown(context.Background()) }()
+
+	// Очередь отменяется сразу, новые задачи не принимаются
+	waitStatus(t, m, queued.ID, StatusCanceled)
+	if _, err := m.Submit("req-1", r.run); !errors.Is(err, ErrClosed) {
+		t.Errorf("Submit() during shutdown error = %v, want ErrClosed", err)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown() = %v before the running job finished", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(r.release)
+	if err := <-done; err != nil {
+		t.Errorf("Shutdown() error = %v, want nil after draining", err)
+	}
+	if job, _ := m.Get(running.ID); job.Status != StatusSucceeded {
+		t.Errorf("running job status = %s, want succeeded", job.Status)
+	}
+}
+
+func TestShutdownCancelsAfterDeadline(t *testing.T) {
+	r := newBlockingRunner()
+	m := NewManager(Config{Workers: 1, QueueSize: 1})
+
+	running, _ := m.Submit("req-1", r.run)
+	r.waitStarted(t, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := m.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown() error = %v, want context.DeadlineExceeded", err)
+	}
+	if job, _ := m.Get(running.ID); job.Status != StatusCanceled {
+		t.Errorf("running job status = %s, want canceled by shutdown", job.Status)
+	}
+}
+
+func TestPruneFinishedJobs(t *testing.T) {
+	r := newBlockingRunner()
+	m := NewManager(Config{Workers: 1, QueueSize: 2, TTL: 30 * time.Millisecond})
+	shutdown(t, m, r)
+
+	finished, _ := m.Submit("req-1", r.run)
+	r.waitStarted(t, 1)
+	close(r.release)
+	waitStatus(t, m, finished.ID, StatusSucceeded)
+
+	r.release = make(chan struct{})
+	running, _ := m.Submit("req-1", r.run)
+	r.waitStarted(t, 1)
+
+	time.Sleep(50 * time.Millisecond)
+	// Очистка выполняется при постановке новой задачи
+	if _, err := m.Submit("req-1", r.run); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if _, err := m.Get(finished.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(finished after TTL) error = %v, want ErrNotFound", err)
+	}
+	if _, err := m.Get(running.ID); err != nil {
+		t.Errorf("Get(running) error = %v: unfinished jobs are never pruned", err)
+	}
+}
@@ -0,0 +1,364 @@
+package langfuse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"langfuse-analyzer-backend/apperr"
+)
+
+// Ошибки, по которым вызывающий код может различать ответы Langfuse
+var (
+	ErrNotFound     = errors.New("объект не найден в Langfuse")
+	ErrUnauthorized = errors.New("неверные ключи Langfuse API")
+	ErrRateLimited  = errors.New("превышен лимит запросов к Langfuse API")
+)
+
+// APIError - ошибка ответа Langfuse API с HTTP статусом
+type APIError struct {
+	StatusCode int
+	Message    string
+	RetryAfter int // секунды
+}
+
+func (e *APIError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("Langfuse API вернул статус %d: %s (retry after %d seconds)", e.StatusCode, e.Message, e.RetryAfter)
+	}
+	return fmt.Sprintf("Langfuse API вернул статус %d: %s", e.StatusCode, e.Message)
+}
+
+// Unwrap позволяет использовать errors.Is с ErrNotFound, ErrUnauthorized и ErrRateLimited
+func (e *APIError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrUnauthorized
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// AppError относит ошибку Langfuse к таксономии API по HTTP статусу.
+// 404 считается ненайденным трейсом; для сессий вызывающий код уточняет код сам.
+func (e *APIError) AppError() *apperr.Error {
+	appErr := &apperr.Error{Err: e}
+	switch {
+	case e.StatusCode == http.StatusNotFound:
+		appErr.Code = apperr.CodeTraceNotFound
+		appErr.Message = "Трейс не найден в Langfuse"
+	case e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden:
+		appErr.Code = apperr.CodeAuthFailed
+		appErr.Message = "Langfuse отклонил ключи API. Проверьте LANGFUSE_PUBLIC_KEY и LANGFUSE_SECRET_KEY."
+	case e.StatusCode == http.StatusTooManyRequests:
+		appErr.Code = apperr.CodeRateLimited
+		appErr.Message = "Слишком много запросов к Langfuse. Попробуйте позже."
+		appErr.RetryAfter = e.RetryAfter
+		if appErr.RetryAfter == 0 {
+			appErr.RetryAfter = 10
+		}
+	case e.StatusCode >= 500:
+		appErr.Code = apperr.CodeUpstreamUnavailable
+		appErr.Message = "Langfuse недоступен"
+	default:
+		appErr.Code = apperr.CodeUpstreamError
+		appErr.Message = "Langfuse вернул ошибку"
+	}
+	return appErr
+}
+
+// retryable сообщает, имеет ли смысл повторять запрос после такого ответа
+func (e *APIError) retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// Fetcher - интерфейс для получения данных из Langfuse
+type Fetcher interface {
+	GetTrace(ctx context.Context, traceID string) (*Trace, error)
+	ListTraces(ctx context.Context, filter TraceFilter) ([]TraceSummary, error)
+	GetSession(ctx context.Context, sessionID string) (*Session, error)
+}
+
+// Config - настройки клиента Langfuse
+type Config struct {
+	BaseURL    string
+	PublicKey  string
+	SecretKey  string
+	Timeout    time.Duration // таймаут одного HTTP запроса
+	MaxRetries int           // общее число попыток
+	BaseDelay  time.Duration // начальная задержка между попытками
+	MaxDelay   time.Duration // максимальная задержка между попытками
+	Observer   Observer      // получатель событий запросов, например метрики; может быть nil
+}
+
+// Observer получает события запросов клиента. operation - вид запроса:
+// trace, traces, session, health, projects.
+type Observer interface {
+	// ObserveRequest вызывается после каждой попытки запроса; status 0 -
+	// ответ не получен (нет соединения, таймаут, отмена)
+	ObserveRequest(operation string, status int, duration time.Duration)
+	// ObserveRetry вызывается перед повтором запроса
+	ObserveRetry(operation string)
+}
+
+// Client - клиент Langfuse Public API
+type Client struct {
+	baseURL    string
+	publicKey  string
+	secretKey  string
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+	httpClient *http.Client
+	observer   Observer
+}
+
+// NewClient создает клиента Langfuse, подставляя значения по умолчанию
+func NewClient(cfg Config) *Client {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = "https://cloud.langfuse.com"
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = 3
+	}
+	if cfg.BaseDelay <= 0 {
+		cfg.BaseDelay = 500 * time.Millisecond
+	}
+	if cfg.MaxDelay <= 0 {
+		cfg.MaxDelay = 10 * time.Second
+	}
+
+	return &Client{
+		baseURL:    strings.TrimRight(cfg.BaseURL, "/"),
+		publicKey:  cfg.PublicKey,
+		secretKey:  cfg.SecretKey,
+		maxRetries: cfg.MaxRetries,
+		baseDelay:  cfg.BaseDelay,
+		maxDelay:   cfg.MaxDelay,
+		httpClient: &http.Client{Timeout: cfg.Timeout},
+		observer:   cfg.Observer,
+	}
+}
+
+// GetTrace получает трейс со всеми наблюдениями и оценками
+func (c *Client) GetTrace(ctx context.Context, traceID string) (*Trace, error) {
+	var trace Trace
+	if err := c.get(ctx, "trace", "/api/public/traces/"+url.PathEscape(traceID), &trace); err != nil {
+		return nil, err
+	}
+	return &trace, nil
+}
+
+// GetSession получает сессию. Трейсы сессии возвращаются без наблюдений,
+// полные данные нужно запрашивать через GetTrace.
+func (c *Client) GetSession(ctx context.Context, sessionID string) (*Session, error) {
+	var session Session
+	if err := c.get(ctx, "session", "/api/public/sessions/"+url.PathEscape(sessionID), &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// maxPageSize - максимальный размер страницы, который принимает Langfuse API
+const maxPageSize = 100
+
+// ListTraces возвращает трейсы, подходящие под фильтр, проходя по страницам,
+// пока не наберется filter.Limit трейсов (по умолчанию одна страница)
+func (c *Client) ListTraces(ctx context.Context, filter TraceFilter) ([]TraceSummary, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = maxPageSize
+	}
+
+	query := url.Values{}
+	setQuery := func(key, value string) {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	setQuery("name", filter.Name)
+	setQuery("userId", filter.UserID)
+	setQuery("sessionId", filter.SessionID)
+	setQuery("release", filter.Release)
+	setQuery("version", filter.Version)
+	setQuery("orderBy", filter.OrderBy)
+	for _, tag := range filter.Tags {
+		query.Add("tags", tag)
+	}
+	for _, env := range filter.Environment {
+		query.Add("environment", env)
+	}
+	if filter.FromTimestamp != nil {
+		query.Set("fromTimestamp", filter.FromTimestamp.UTC().Format(time.RFC3339))
+	}
+	if filter.ToTimestamp != nil {
+		query.Set("toTimestamp", filter.ToTimestamp.UTC().Format(time.RFC3339))
+	}
+	query.Set("limit", strconv.Itoa(min(limit, maxPageSize)))
+
+	var traces []TraceSummary
+	for page := 1; len(traces) < limit; page++ {
+		query.Set("page", strconv.Itoa(page))
+
+		var resp struct {
+			Data []TraceSummary `json:"data"`
+			Meta struct {
+				TotalPages int `json:"totalPages"`
+			} `json:"meta"`
+		}
+		if err := c.get(ctx, "traces", "/api/public/traces?"+query.Encode(), &resp); err != nil {
+			return nil, err
+		}
+
+		traces = append(traces, resp.Data...)
+		if len(resp.Data) == 0 || page >= resp.Meta.TotalPages {
+			break
+		}
+	}
+
+	if len(traces) > limit {
+		traces = traces[:limit]
+	}
+	return traces, nil
+}
+
+// Check проверяет, что Langfuse доступен (/api/public/health) и принимает
+// ключи (/api/public/projects возвращает проект ключа). Один запрос на
+// каждый шаг, без повторов: проверка должна отвечать быстро.
+func (c *Client) Check(ctx context.Context) error {
+	var health struct {
+		Status string `json:"status"`
+	}
+	if err := c.doGet(ctx, "health", c.baseURL+"/api/public/health", &health); err != nil {
+		return err
+	}
+	var projects struct {
+		Data []json.RawMessage `json:"data"`
+	}
+	return c.doGet(ctx, "projects", c.baseURL+"/api/public/projects", &projects)
+}
+
+// get выполняет GET запрос с повторами и декодирует JSON ответ в out
+func (c *Client) get(ctx context.Context, operation, path string, out interface{}) error {
+	endpoint := c.baseURL + path
+	log.Printf("   🌐 Запрос к Langfuse API: %s", endpoint)
+
+	var lastErr error
+	for attempt := 1; attempt <= c.maxRetries; attempt++ {
+		if attempt > 1 {
+			delay := c.backoff(attempt, lastErr)
+			log.Printf("   🔄 Попытка %d/%d через %v", attempt, c.maxRetries, delay)
+			if c.observer != nil {
+				c.observer.ObserveRetry(operation)
+			}
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+
+		lastErr = c.doGet(ctx, operation, endpoint, out)
+		if lastErr == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		var apiErr *APIError
+		if errors.As(lastErr, &apiErr) && !apiErr.retryable() {
+			log.Printf("   ❌ Ошибка не подлежит повтору: %v", lastErr)
+			return lastErr
+		}
+		log.Printf("   ⚠️  Ошибка запроса (попытка %d): %v", attempt, lastErr)
+	}
+
+	log.Printf("   ❌ Все попытки исчерпаны")
+	return lastErr
+}
+
+// doGet выполняет одну попытку запроса
+func (c *Client) doGet(ctx context.Context, operation, endpoint string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("ошибка создания запроса к Langfuse: %w", err)
+	}
+	req.SetBasicAuth(c.publicKey, c.secretKey)
+	req.Header.Set("Accept", "application/json")
+
+	// Время запроса - вместе с чтением тела: большие трейсы загружаются долго
+	started := time.Now()
+	status := 0
+	if c.observer != nil {
+		defer func() {
+			c.observer.ObserveRequest(operation, status, time.Since(started))
+		}()
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() && ctx.Err() == nil {
+			return apperr.Wrap(apperr.CodeTimeout, "Истекло время ожидания ответа Langfuse", err)
+		}
+		return apperr.Wrap(apperr.CodeUpstreamUnavailable, "Langfuse недоступен", err)
+	}
+	defer resp.Body.Close()
+	status = resp.StatusCode
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &APIError{
+			StatusCode: resp.StatusCode,
+			Message:    strings.TrimSpace(string(bodyBytes)),
+			RetryAfter: apperr.ParseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	// Ответ декодируется в новое значение: при ошибке декодирования часть полей
+	// уже заполнена, и повторная попытка смешала бы данные двух ответов
+	fresh := reflect.New(reflect.TypeOf(out).Elem())
+	if err := json.NewDecoder(resp.Body).Decode(fresh.Interface()); err != nil {
+		return apperr.Wrap(apperr.CodeUpstreamError, "Langfuse вернул некорректный ответ", err)
+	}
+	reflect.ValueOf(out).Elem().Set(fresh.Elem())
+	return nil
+}
+
+// backoff вычисляет задержку перед попыткой: экспонента со случайным джиттером,
+// либо Retry-After из ответа, если он меньше максимальной задержки
+func (c *Client) backoff(attempt int, lastErr error) time.Duration {
+	var apiErr *APIError
+	if errors.As(lastErr, &apiErr) && apiErr.RetryAfter > 0 {
+		if d := time.Duration(apiErr.RetryAfter) * time.Second; d <= c.maxDelay {
+			return d
+		}
+	}
+
+	d := c.baseDelay << (attempt - 2)
+	if d <= 0 || d > c.maxDelay {
+		d = c.maxDelay
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
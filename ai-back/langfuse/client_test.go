@@ -0,0 +1,43 @@
+package langfuse
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetRetryDoesNotMixResponses(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if calls.Add(1) == 1 {
+			// latency неверного типа: декодер заполнит остальные поля и вернёт ошибку
+			w.Write([]byte(`{"id":"trace-1","name":"stale","tags":["stale"],"metadata":{"stale":true},"latency":"bad"}`))
+			return
+		}
+		w.Write([]byte(`{"id":"trace-1","metadata":{"fresh":true},"latency":1.5,"observations":[]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(Config{BaseURL: server.URL, MaxRetries: 2, BaseDelay: time.Millisecond})
+	trace, err := client.GetTrace(context.Background(), "trace-1")
+	if err != nil {
+		t.Fatalf("GetTrace: %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("calls = %d, want 2", calls.Load())
+	}
+	if trace.Name != "" || trace.Tags != nil {
+		t.Errorf("name = %q, tags = %v: fields leaked from the failed response", trace.Name, trace.Tags)
+	}
+	if want := map[string]interface{}{"fresh": true}; !reflect.DeepEqual(trace.Metadata, want) {
+		t.Errorf("metadata = %v, want %v", trace.Metadata, want)
+	}
+	if trace.Latency != 1.5 {
+		t.Errorf("latency = %v, want 1.5", trace.Latency)
+	}
+}
@@ -0,0 +1,208 @@
+package langfuse
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// ObservationType - тип наблюдения (шага) внутри трейса
+type ObservationType string
+
+const (
+	ObservationSpan       ObservationType = "SPAN"
+	ObservationGeneration ObservationType = "GENERATION"
+	ObservationEvent      ObservationType = "EVENT"
+	ObservationAgent      ObservationType = "AGENT"
+	ObservationTool       ObservationType = "TOOL"
+	ObservationChain      ObservationType = "CHAIN"
+	ObservationRetriever  ObservationType = "RETRIEVER"
+	ObservationEvaluator  ObservationType = "EVALUATOR"
+	ObservationEmbedding  ObservationType = "EMBEDDING"
+	ObservationGuardrail  ObservationType = "GUARDRAIL"
+)
+
+// ObservationLevel - уровень важности наблюдения
+type ObservationLevel string
+
+const (
+	LevelDebug   ObservationLevel = "DEBUG"
+	LevelDefault ObservationLevel = "DEFAULT"
+	LevelWarning ObservationLevel = "WARNING"
+	LevelError   ObservationLevel = "ERROR"
+)
+
+// Trace - трейс из Langfuse API (GET /api/public/traces/{id}).
+// Неизвестные поля игнорируются, произвольные данные (input, output, metadata)
+// сохраняются как есть.
+type Trace struct {
+	ID           string        `json:"id"`
+	Name         string        `json:"name,omitempty"`
+	Timestamp    time.Time     `json:"timestamp"`
+	UserID       string        `json:"userId,omitempty"`
+	SessionID    string        `json:"sessionId,omitempty"`
+	Release      string        `json:"release,omitempty"`
+	Version      string        `json:"version,omitempty"`
+	Environment  string        `json:"environment,omitempty"`
+	Tags         []string      `json:"tags,omitempty"`
+	Public       bool          `json:"public,omitempty"`
+	Input        interface{}   `json:"input,omitempty"`
+	Output       interface{}   `json:"output,omitempty"`
+	Metadata     interface{}   `json:"metadata,omitempty"`
+	Latency      float64       `json:"latency"`   // секунды
+	TotalCost    float64       `json:"totalCost"` // USD
+	HTMLPath     string        `json:"htmlPath,omitempty"`
+	Observations []Observation `json:"observations"`
+	Scores       []Score       `json:"scores,omitempty"`
+}
+
+// Observation - отдельный шаг трейса (span, generation, event, tool call и т.д.)
+type Observation struct {
+	ID                  string                 `json:"id"`
+	TraceID             string                 `json:"traceId,omitempty"`
+	ParentObservationID string                 `json:"parentObservationId,omitempty"`
+	Type                ObservationType        `json:"type"`
+	Name                string                 `json:"name,omitempty"`
+	StartTime           time.Time              `json:"startTime"`
+	EndTime             *time.Time             `json:"endTime,omitempty"`
+	CompletionStartTime *time.Time             `json:"completionStartTime,omitempty"`
+	Level               ObservationLevel       `json:"level,omitempty"`
+	StatusMessage       string                 `json:"statusMessage,omitempty"`
+	Version             string                 `json:"version,omitempty"`
+	Model               string                 `json:"model,omitempty"`
+	ModelParameters     map[string]interface{} `json:"modelParameters,omitempty"`
+	Input               interface{}            `json:"input,omitempty"`
+	Output              interface{}            `json:"output,omitempty"`
+	Metadata            interface{}            `json:"metadata,omitempty"`
+	Usage               *Usage                 `json:"usage,omitempty"`
+	UsageDetails        map[string]int         `json:"usageDetails,omitempty"`
+	CostDetails         map[string]float64     `json:"costDetails,omitempty"`
+	CalculatedTotalCost float64                `json:"calculatedTotalCost,omitempty"` // USD
+	Latency             float64                `json:"latency,omitempty"`             // секунды
+	TimeToFirstToken    float64                `json:"timeToFirstToken,omitempty"`    // секунды
+}
+
+// Usage - использование токенов (и стоимость) для GENERATION
+type Usage struct {
+	Input      int     `json:"input"`
+	Output     int     `json:"output"`
+	Total      int     `json:"total"`
+	Unit       string  `json:"unit,omitempty"`
+	InputCost  float64 `json:"inputCost,omitempty"`
+	OutputCost float64 `json:"outputCost,omitempty"`
+	TotalCost  float64 `json:"totalCost,omitempty"`
+}
+
+// Score - оценка, привязанная к трейсу или наблюдению
+type Score struct {
+	ID            string      `json:"id"`
+	TraceID       string      `json:"traceId,omitempty"`
+	ObservationID string      `json:"observationId,omitempty"`
+	Name          string      `json:"name"`
+	Value         interface{} `json:"value,omitempty"` // число или строка в зависимости от dataType
+	StringValue   string      `json:"stringValue,omitempty"`
+	DataType      string      `json:"dataType,omitempty"`
+	Source        string      `json:"source,omitempty"`
+	Comment       string      `json:"comment,omitempty"`
+	Timestamp     time.Time   `json:"timestamp"`
+}
+
+// Duration возвращает длительность наблюдения. Если поле latency отсутствует,
+// она вычисляется по startTime/endTime.
+func (o *Observation) Duration() time.Duration {
+	if o.Latency > 0 {
+		return time.Duration(o.Latency * float64(time.Second))
+	}
+	if o.EndTime != nil && !o.StartTime.IsZero() {
+		return o.EndTime.Sub(o.StartTime)
+	}
+	return 0
+}
+
+// Cost возвращает стоимость наблюдения в USD
+func (o *Observation) Cost() float64 {
+	if o.CalculatedTotalCost > 0 {
+		return o.CalculatedTotalCost
+	}
+	if o.Usage != nil && o.Usage.TotalCost > 0 {
+		return o.Usage.TotalCost
+	}
+	return 0
+}
+
+// TotalTokens возвращает общее число токенов наблюдения
+func (o *Observation) TotalTokens() int {
+	if o.Usage != nil && o.Usage.Total > 0 {
+		return o.Usage.Total
+	}
+	if total, ok := o.UsageDetails["total"]; ok {
+		return total
+	}
+	sum := 0
+	for _, v := range o.UsageDetails {
+		sum += v
+	}
+	return sum
+}
+
+// IsError сообщает, завершилось ли наблюдение ошибкой
+func (o *Observation) IsError() bool {
+	return o.Level == LevelError
+}
+
+// HashValue возвращает короткий отпечаток значения input или output
+// наблюдения: одинаковые значения дают одинаковый отпечаток. Пустая
+// строка - значение не сериализуется в JSON.
+func HashValue(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// TraceSummary - трейс из списка (GET /api/public/traces). В отличие от Trace,
+// содержит только ID наблюдений и оценок.
+type TraceSummary struct {
+	ID             string    `json:"id"`
+	Name           string    `json:"name,omitempty"`
+	Timestamp      time.Time `json:"timestamp"`
+	UserID         string    `json:"userId,omitempty"`
+	SessionID      string    `json:"sessionId,omitempty"`
+	Release        string    `json:"release,omitempty"`
+	Version        string    `json:"version,omitempty"`
+	Environment    string    `json:"environment,omitempty"`
+	Tags           []string  `json:"tags,omitempty"`
+	Latency        float64   `json:"latency"`   // секунды
+	TotalCost      float64   `json:"totalCost"` // USD
+	HTMLPath       string    `json:"htmlPath,omitempty"`
+	ObservationIDs []string  `json:"observations,omitempty"`
+	ScoreIDs       []string  `json:"scores,omitempty"`
+}
+
+// TraceFilter - параметры выборки трейсов для ListTraces
+type TraceFilter struct {
+	Name          string     `json:"name,omitempty"`
+	UserID        string     `json:"userId,omitempty"`
+	SessionID     string     `json:"sessionId,omitempty"`
+	Tags          []string   `json:"tags,omitempty"`
+	Release       string     `json:"release,omitempty"`
+	Version       string     `json:"version,omitempty"`
+	Environment   []string   `json:"environment,omitempty"`
+	FromTimestamp *time.Time `json:"fromTimestamp,omitempty"`
+	ToTimestamp   *time.Time `json:"toTimestamp,omitempty"`
+	OrderBy       string     `json:"orderBy,omitempty"` // например "timestamp.desc"
+	Limit         int        `json:"limit,omitempty"`   // максимальное число трейсов в результате
+}
+
+// Session - сессия Langfuse (GET /api/public/sessions/{id}): трейсы одного
+// многошагового диалога, связанные общим sessionId
+type Session struct {
+	ID          string    `json:"id"`
+	CreatedAt   time.Time `json:"createdAt"`
+	ProjectID   string    `json:"projectId,omitempty"`
+	Environment string    `json:"environment,omitempty"`
+	Traces      []Trace   `json:"traces"`
+}
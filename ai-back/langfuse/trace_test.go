@@ -0,0 +1,18 @@
+package langfuse
+
+import "testing"
+
+func TestHashValue(t *testing.T) {
+	a := map[string]interface{}{"query": "доставка", "limit": 10}
+	b := map[string]interface{}{"limit": 10, "query": "доставка"}
+
+	if HashValue(a) == "" || HashValue(a) != HashValue(b) {
+		t.Errorf("equal values: %q and %q, want the same non-empty hash", HashValue(a), HashValue(b))
+	}
+	if HashValue(a) == HashValue(map[string]interface{}{"query": "оплата", "limit": 10}) {
+		t.Error("different values have the same hash")
+	}
+	if HashValue(func() {}) != "" {
+		t.Error("value that is not JSON must have an empty hash")
+	}
+}
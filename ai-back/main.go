@@ -1,18 +1,18 @@
 package main
 
 import (
-	"encoding/json"
-	"errors"
-	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
-	"strconv"
+	"slices"
 	"strings"
 	"time"
 
 	"langfuse-analyzer-backend/ai"
+	"langfuse-analyzer-backend/config"
+	"langfuse-analyzer-backend/jobs"
+	"langfuse-analyzer-backend/langfuse"
+	"langfuse-analyzer-backend/metrics"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -20,10 +20,15 @@ import (
 )
 
 type AnalyzeRequest struct {
-	TraceID string `json:"traceId"`
+	TraceID  string `json:"traceId"`
+	Language string `json:"language"` // код языка отчета, например "en"; пусто - ANALYSIS_LANGUAGE
 }
 
-var aiClient ai.AIClient
+var (
+	aiClient       ai.AIClient
+	analyzer       *ai.Analyzer
+	langfuseClient langfuse.Fetcher
+)
 
 func main() {
 	err := godotenv.Load()
@@ -32,108 +37,113 @@ func main() {
 	}
 
 	// ====================================================================
-	// ОПРЕДЕЛЕНИЕ AI ПРОВАЙДЕРА
+	// КОНФИГУРАЦИЯ: значения по умолчанию < файл < окружение < флаги
 	// ====================================================================
-	aiProvider := strings.ToLower(os.Getenv("AI_PROVIDER"))
-	if aiProvider == "" {
-		aiProvider = "openrouter" // По умолчанию OpenRouter
+	opts, err := config.ParseFlags(os.Args[1:])
+	if err != nil {
+		log.Fatalf("❌ %v", err)
 	}
-
-	var provider ai.ProviderType
-	switch aiProvider {
-	case "ollama":
-		provider = ai.ProviderOllama
-		log.Println("🤖 Используется AI провайдер: OLLAMA")
-	case "openrouter":
-		provider = ai.ProviderOpenRouter
-		log.Println("🤖 Используется AI провайдер: OPENROUTER")
-	default:
-		log.Fatalf("❌ Неизвестный AI провайдер: %s. Доступные: openrouter, ollama", aiProvider)
+	cfg, err := config.Load(opts)
+	if err != nil {
+		log.Fatalf("❌ Ошибка загрузки конфигурации: %v", err)
+	}
+	if opts.PrintConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatalf("❌ Ошибка вывода конфигурации: %v", err)
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("❌ Неверная конфигурация:\n%v", err)
+	}
+	if opts.PrintConfig {
+		return
+	}
+	if opts.ConfigFile != "" {
+		log.Printf("🗂️  Файл конфигурации: %s", opts.ConfigFile)
 	}
 
 	// ====================================================================
-	// КОНФИГУРАЦИЯ AI КЛИЕНТА
+	// ОПРЕДЕЛЕНИЕ AI ПРОВАЙДЕРА
 	// ====================================================================
-	var apiKey, baseURL, aiModel string
-	var maxTokens int
-
-	if provider == ai.ProviderOllama {
-		// Для Ollama API ключ не нужен
-		baseURL = os.Getenv("OLLAMA_BASE_URL")
-		if baseURL == "" {
-			baseURL = "http://localhost:11434"
-			log.Printf("OLLAMA_BASE_URL не указан, используем по умолчанию: %s", baseURL)
-		}
+	log.Printf("📊 Максимум токенов для AI: %d", cfg.AI.MaxTokens)
 
-		aiModel = os.Getenv("OLLAMA_MODEL")
-		if aiModel == "" {
-			aiModel = "llama3.2"
-			log.Printf("OLLAMA_MODEL не указана, используем по умолчанию: %s", aiModel)
-		}
+	// Создаём AI клиента: основной провайдер и резервные, если заданы
+	aiClient = newAIClient(cfg)
+	log.Println("✅ AI клиент успешно инициализирован")
 
-		// Читаем таймаут для Ollama
-		ollamaTimeout := os.Getenv("OLLAMA_TIMEOUT")
-		if ollamaTimeout == "" {
-			ollamaTimeout = "120"
-		}
-		log.Printf("⏱️  Таймаут Ollama: %s секунд", ollamaTimeout)
+	// Число обращений к модели на один анализ, включая попытки исправить
+	// ответ, не прошедший проверку формата
+	log.Printf("🔁 Попыток получить корректный ответ модели: %d", cfg.AI.MaxAttempts)
 
-		log.Printf("📍 Ollama URL: %s", baseURL)
-		log.Printf("🧠 Модель Ollama: %s", aiModel)
+	// Шаблоны промптов: встроенные, с переопределением из ai.promptsDir
+	prompts, err := ai.LoadPrompts(cfg.AI.PromptsDir)
+	if err != nil {
+		log.Fatalf("❌ Ошибка загрузки шаблонов промптов: %v", err)
+	}
+	log.Printf("📝 Версия промптов: %s", prompts.Version())
 
-	} else {
-		// Для OpenRouter нужен API ключ
-		apiKey = os.Getenv("AI_API_KEY")
-		if apiKey == "" {
-			log.Fatal("❌ Переменная окружения AI_API_KEY не установлена (требуется для OpenRouter).")
-		}
+	analyzer = ai.NewAnalyzer(aiClient, prompts, cfg.AI.MaxAttempts)
 
-		baseURL = os.Getenv("AI_BASE_URL")
-		if baseURL == "" {
-			baseURL = "https://openrouter.ai/api/v1"
+	// Язык отчетов по умолчанию; запрос может указать свой в поле language
+	if cfg.AI.Language != "" {
+		defaultLanguage, err = ai.NormalizeLanguage(cfg.AI.Language)
+		if err != nil {
+			log.Fatalf("❌ Неверное значение ANALYSIS_LANGUAGE: %v. Доступные: %s",
+				err, strings.Join(ai.SupportedLanguages(), ", "))
 		}
+	}
+	log.Printf("🌐 Язык отчетов по умолчанию: %s", defaultLanguage)
 
-		aiModel = os.Getenv("AI_MODEL")
-		if aiModel == "" {
-			aiModel = "google/gemini-2.0-flash-exp:free"
-			log.Printf("AI_MODEL не указана, используем по умолчанию: %s", aiModel)
-		}
+	// ====================================================================
+	// КОНФИГУРАЦИЯ LANGFUSE КЛИЕНТА
+	// ====================================================================
+	langfuseClient = langfuse.NewClient(langfuse.Config{
+		BaseURL:    cfg.Langfuse.BaseURL,
+		PublicKey:  cfg.Langfuse.PublicKey,
+		SecretKey:  cfg.Langfuse.SecretKey,
+		Timeout:    cfg.Langfuse.Timeout,
+		MaxRetries: cfg.Langfuse.MaxRetries,
+		Observer:   metrics.Langfuse{},
+	})
+	log.Printf("📍 Langfuse URL: %s", cfg.Langfuse.BaseURL)
 
-		log.Printf("🧠 Модель OpenRouter: %s", aiModel)
-	}
+	// ====================================================================
+	// ОЧЕРЕДЬ АСИНХРОННЫХ ЗАДАЧ
+	// ====================================================================
+	jobManager = jobs.NewManager(jobs.Config{
+		Workers:   cfg.Jobs.Workers,
+		QueueSize: cfg.Jobs.QueueSize,
+		Timeout:   cfg.Jobs.Timeout,
+	})
+	log.Printf("⚙️  Очередь задач: %d воркеров", cfg.Jobs.Workers)
 
-	// Читаем max_tokens из переменных окружения (по умолчанию 1000)
-	maxTokensStr := os.Getenv("AI_MAX_TOKENS")
-	maxTokens = 1000
-	if maxTokensStr != "" {
-		if parsed, err := strconv.Atoi(maxTokensStr); err == nil {
-			maxTokens = parsed
-		} else {
-			log.Printf("⚠️ Неверное значение AI_MAX_TOKENS: %s, используем 1000", maxTokensStr)
-		}
-	}
-	log.Printf("📊 Максимум токенов для AI: %d", maxTokens)
+	batchMaxTraces = cfg.Limits.BatchMaxTraces
+	batchMaxWorkers = cfg.Limits.BatchConcurrency
+	log.Printf("📦 Пакетный анализ: до %d трейсов, %d параллельно", batchMaxTraces, batchMaxWorkers)
 
-	// Создаём AI клиента
-	aiClient = ai.NewAIClient(provider, apiKey, baseURL, aiModel, maxTokens)
-	log.Println("✅ AI клиент успешно инициализирован")
+	compactBudget.MaxTokens = cfg.Limits.TraceTokenBudget
+	compactBudget.MaxFieldChars = cfg.Limits.TraceMaxFieldChars
+	log.Printf("✂️  Бюджет трейса для модели: ~%d токенов", compactBudget.MaxTokens)
+
+	chunkMaxObservations = cfg.Limits.ChunkMaxObservations
+	chunkMaxParts = cfg.Limits.ChunkMaxParts
+	chunkConcurrency = cfg.Limits.ChunkConcurrency
+	log.Printf("🧩 Анализ по частям: до %d частей, %d параллельно", chunkMaxParts, chunkConcurrency)
+
+	sessionMaxTraces = cfg.Limits.SessionMaxTraces
+	log.Printf("💬 Анализ сессий: до %d трейсов", sessionMaxTraces)
 
 	// ====================================================================
 	// НАСТРОЙКА CHROME EXTENSION CORS
 	// ====================================================================
-	chromeExtensionID := os.Getenv("CHROME_EXTENSION_ID")
-	if chromeExtensionID == "" {
-		log.Fatal("❌ Переменная окружения CHROME_EXTENSION_ID не установлена.")
-	}
-
 	router := gin.Default()
 
-	chromeExtensionOrigin := "chrome-extension://" + chromeExtensionID
-	log.Printf("🔐 Разрешаем CORS для: %s", chromeExtensionOrigin)
+	allowedOrigins := cfg.CORS.AllowedOrigins()
+	log.Printf("🔐 Разрешаем CORS для: %s", strings.Join(allowedOrigins, ", "))
 
-	config := cors.Config{
+	corsConfig := cors.Config{
 		AllowOriginFunc: func(origin string) bool {
-			allowed := origin == chromeExtensionOrigin
+			allowed := slices.Contains(allowedOrigins, origin)
 			if allowed {
 				log.Printf("CORS: Разрешен запрос от %s", origin)
 			} else {
@@ -142,23 +152,37 @@ func main() {
 			return allowed
 		},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", requestIDHeader},
+		ExposeHeaders:    []string{"Content-Length", requestIDHeader},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}
 
-	router.Use(cors.New(config))
+	router.Use(cors.New(corsConfig))
+	router.Use(requestID())
+	router.Use(observeRequests())
 
 	// ====================================================================
 	// РОУТЫ
 	// ====================================================================
 	router.POST("/analyze", handleAnalyzeRequest)
+	router.GET("/analyze/stream", handleAnalyzeStream)
+	router.POST("/analyze/stream", handleAnalyzeStream)
+	router.POST("/analyze/batch", handleAnalyzeBatch)
+	router.POST("/analyze/session", handleAnalyzeSession)
+	router.POST("/jobs", handleCreateJob)
+	router.GET("/jobs/:id", handleGetJob)
+	router.DELETE("/jobs/:id", handleCancelJob)
+	router.GET("/providers/status", handleProviderStatus)
+	router.GET("/healthz", handleHealthz)
+	router.GET("/health", handleHealthz)
+	router.GET("/readyz", handleReadyz)
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
 
 	log.Println("==============================================")
-	log.Println("🚀 Go-сервис запущен на http://localhost:8080")
+	log.Printf("🚀 Go-сервис запущен на %s", cfg.Server.Listen)
 	log.Println("==============================================")
-	router.Run(":8080")
+	serve(router, cfg.Server)
 }
 
 func handleAnalyzeRequest(c *gin.Context) {
@@ -172,178 +196,35 @@ func handleAnalyzeRequest(c *gin.Context) {
 	var req AnalyzeRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		log.Printf("❌ Ошибка парсинга JSON: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		c.JSON(errorResponse(c, invalidRequest("Некорректный JSON: "+err.Error())))
 		return
 	}
-
-	log.Printf("✅ Получен запрос на анализ traceId: %s", req.TraceID)
-	log.Println("----------------------------------------------")
-	log.Println("🔄 ШАГ 1: Получение данных трейса из Langfuse")
-
-	traceData, err := getTraceFromLangfuse(req.TraceID)
+	if req.TraceID == "" {
+		c.JSON(errorResponse(c, invalidRequest("Не указан traceId")))
+		return
+	}
+	language, err := resolveLanguage(req.Language)
 	if err != nil {
-		log.Printf("❌ Ошибка получения трейса: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get trace from Langfuse: " + err.Error()})
+		c.JSON(errorResponse(c, languageError(err)))
 		return
 	}
 
-	log.Printf("✅ Трейс получен, размер данных: %d байт", len(fmt.Sprintf("%v", traceData)))
+	log.Printf("✅ Получен запрос на анализ traceId: %s", req.TraceID)
 	log.Println("----------------------------------------------")
-	log.Println("🤖 ШАГ 2: Отправка на анализ AI")
 
-	analysisResult, err := aiClient.AnalyzeTrace(c.Request.Context(), traceData)
+	resp, err := runAnalysis(c.Request.Context(), req.TraceID, language)
 	if err != nil {
-		log.Printf("❌ Ошибка анализа AI: %v", err)
-
-		// Проверяем если это наша кастомная AIError
-		var aiErr *ai.AIError
-		if errors.As(err, &aiErr) {
-			switch aiErr.StatusCode {
-			case 429:
-				log.Printf("⚠️  Rate limit от AI провайдера, retry after %d секунд", aiErr.RetryAfter)
-				c.JSON(http.StatusTooManyRequests, gin.H{
-					"error":      "Слишком много запросов к AI. Попробуйте позже.",
-					"code":       "RATE_LIMIT",
-					"retryAfter": aiErr.RetryAfter,
-				})
-				return
-			case 402:
-				log.Println("⚠️  Недостаточно кредитов на AI провайдере")
-				c.JSON(http.StatusPaymentRequired, gin.H{
-					"error": "Недостаточно кредитов для AI анализа. Пополните баланс на OpenRouter.",
-					"code":  "INSUFFICIENT_CREDITS",
-				})
-				return
-			case 503:
-				log.Println("⚠️  AI сервис недоступен")
-				c.JSON(http.StatusServiceUnavailable, gin.H{
-					"error": aiErr.Message,
-					"code":  "SERVICE_UNAVAILABLE",
-				})
-				return
-			default:
-				c.JSON(aiErr.StatusCode, gin.H{
-					"error": aiErr.Message,
-				})
-				return
-			}
-		}
-
-		// Проверяем тип ошибки по тексту (fallback для старых ошибок)
-		errorMsg := err.Error()
-
-		// 429 Too Many Requests - rate limit
-		if contains(errorMsg, "429") || contains(errorMsg, "Too Many Requests") || contains(errorMsg, "rate limit") {
-			log.Println("⚠️  Rate limit от AI провайдера, возвращаем 429")
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error":      "Слишком много запросов к AI. Попробуйте через несколько секунд.",
-				"code":       "RATE_LIMIT",
-				"retryAfter": 10,
-			})
-			return
-		}
-
-		// 402 Payment Required - недостаточно кредитов
-		if contains(errorMsg, "402") || contains(errorMsg, "credits") || contains(errorMsg, "Payment Required") {
-			log.Println("⚠️  Недостаточно кредитов на AI провайдере")
-			c.JSON(http.StatusPaymentRequired, gin.H{
-				"error": "Недостаточно кредитов для AI анализа. Пополните баланс на OpenRouter.",
-				"code":  "INSUFFICIENT_CREDITS",
-			})
-			return
-		}
-
-		// Остальные ошибки - 500
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to analyze trace with LLM: " + err.Error()})
+		c.JSON(errorResponse(c, err))
 		return
 	}
 
-	log.Printf("✅ AI анализ завершён, длина ответа: %d символов", len(analysisResult))
 	log.Println("----------------------------------------------")
 	log.Println("📤 ШАГ 3: Отправка результата в браузер")
 
-	var structuredResponse map[string]interface{}
-	if err := json.Unmarshal([]byte(analysisResult), &structuredResponse); err != nil {
-		log.Println("⚠️  Ответ не в формате JSON, отправляем как строку")
-		c.JSON(http.StatusOK, gin.H{"data": analysisResult})
-	} else {
-		log.Println("✅ Ответ распарсен как JSON")
-		c.JSON(http.StatusOK, gin.H{"data": structuredResponse})
-	}
+	c.JSON(http.StatusOK, resp)
 
 	log.Println("==============================================")
 	log.Println("✅ ЗАПРОС УСПЕШНО ОБРАБОТАН")
 	log.Println("==============================================")
 	log.Println()
 }
-
-// contains проверяет содержится ли подстрока в строке (case-insensitive)
-func contains(s, substr string) bool {
-	return len(s) >= len(substr) && (s == substr ||
-		len(s) > len(substr) && (s[:len(substr)] == substr ||
-			s[len(s)-len(substr):] == substr ||
-			containsHelper(s, substr)))
-}
-
-func containsHelper(s, substr string) bool {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return true
-		}
-	}
-	return false
-}
-
-func getTraceFromLangfuse(traceID string) (map[string]interface{}, error) {
-	secretKey := os.Getenv("LANGFUSE_SECRET_KEY")
-	publicKey := os.Getenv("LANGFUSE_PUBLIC_KEY")
-	host := os.Getenv("LANGFUSE_BASEURL")
-
-	url := fmt.Sprintf("%s/api/public/traces/%s", host, traceID)
-	log.Printf("   🌐 Запрос к Langfuse API: %s", url)
-
-	// Увеличиваем таймаут до 30 секунд
-	client := &http.Client{Timeout: 30 * time.Second}
-
-	// Retry логика - 3 попытки
-	var lastErr error
-	for attempt := 1; attempt <= 3; attempt++ {
-		if attempt > 1 {
-			log.Printf("   🔄 Попытка %d/3", attempt)
-			time.Sleep(time.Duration(attempt) * time.Second) // Экспоненциальная задержка
-		}
-
-		req, _ := http.NewRequest("GET", url, nil)
-		req.SetBasicAuth(publicKey, secretKey)
-
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Printf("   ⚠️  Ошибка HTTP запроса (попытка %d): %v", attempt, err)
-			lastErr = err
-			continue
-		}
-		defer resp.Body.Close()
-
-		log.Printf("   ✅ Статус ответа Langfuse: %d %s", resp.StatusCode, resp.Status)
-
-		if resp.StatusCode != http.StatusOK {
-			bodyBytes, _ := io.ReadAll(resp.Body)
-			log.Printf("   ⚠️  Тело ответа: %s", string(bodyBytes))
-			lastErr = fmt.Errorf("Langfuse API вернул статус: %s", resp.Status)
-			continue
-		}
-
-		var data map[string]interface{}
-		if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-			log.Printf("   ❌ Ошибка декодирования JSON: %v", err)
-			lastErr = err
-			continue
-		}
-
-		log.Printf("   ✅ Данные трейса успешно получены")
-		return data, nil
-	}
-
-	log.Printf("   ❌ Все попытки исчерпаны")
-	return nil, lastErr
-}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"langfuse-analyzer-backend/ai"
+	"langfuse-analyzer-backend/apperr"
+	"langfuse-analyzer-backend/jobs"
+	"langfuse-analyzer-backend/langfuse"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeFetcher - Langfuse с заранее заданным ответом
+type fakeFetcher struct {
+	trace *langfuse.Trace
+	err   error
+}
+
+func (f *fakeFetcher) GetTrace(ctx context.Context, traceID string) (*langfuse.Trace, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.trace, nil
+}
+
+func (f *fakeFetcher) ListTraces(ctx context.Context, filter langfuse.TraceFilter) ([]langfuse.TraceSummary, error) {
+	return nil, f.err
+}
+
+func (f *fakeFetcher) GetSession(ctx context.Context, sessionID string) (*langfuse.Session, error) {
+	return nil, f.err
+}
+
+// fakeAI - модель, которая всегда отвечает одним и тем же текстом,
+// через delay после запроса
+type fakeAI struct {
+	answer string
+	delay  time.Duration
+	calls  int
+}
+
+func (f *fakeAI) AnalyzeTrace(ctx context.Context, req *ai.AnalysisRequest) (string, error) {
+	f.calls++
+	time.Sleep(f.delay)
+	return f.answer, nil
+}
+
+func (f *fakeAI) AnalyzeTraceStream(ctx context.Context, req *ai.AnalysisRequest, onToken func(string)) (string, error) {
+	f.calls++
+	onToken(f.answer)
+	return f.answer, nil
+}
+
+const validReport = `{
+  "analysisSummary": {"traceId": "trace-1", "overallStatus": "ERROR", "keyFinding": "Вызов инструмента поиска завершился ошибкой"},
+  "detailedAnalysis": {
+    "anomalyType": "ERROR",
+    "description": "Инструмент поиска вернул ошибку, и агент не смог ответить пользователю",
+    "rootCause": "Внешний сервис поиска недоступен",
+    "recommendation": "Добавить повтор запроса и запасной источник данных"
+  },
+  "findings": [{
+    "type": "ERROR",
+    "severity": "HIGH",
+    "observationIds": ["obs-1"],
+    "evidence": {"errorMessage": "connection refused"},
+    "description": "Наблюдение поиска завершилось с уровнем ошибки",
+    "recommendation": "Проверить доступность сервиса поиска"
+  }]
+}`
+
+// serveAnalyze выполняет POST /analyze с подмененными Langfuse и моделью
+func serveAnalyze(t *testing.T, fetcher langfuse.Fetcher, model ai.AIClient, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	prevFetcher, prevAnalyzer := langfuseClient, analyzer
+	t.Cleanup(func() { langfuseClient, analyzer = prevFetcher, prevAnalyzer })
+	langfuseClient = fetcher
+	analyzer = ai.NewAnalyzer(model, ai.DefaultPrompts(), 2)
+
+	router := gin.New()
+	router.Use(requestID())
+	router.POST("/analyze", handleAnalyzeRequest)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/analyze", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func testTrace() *langfuse.Trace {
+	return &langfuse.Trace{
+		ID:   "trace-1",
+		Name: "search-agent",
+		Observations: []langfuse.Observation{
+			{ID: "obs-1", Type: "SPAN", Name: "search", Level: "ERROR", StatusMessage: "connection refused"},
+		},
+	}
+}
+
+func TestHandleAnalyzeSuccess(t *testing.T) {
+	model := &fakeAI{answer: validReport}
+	w := serveAnalyze(t, &fakeFetcher{trace: testTrace()}, model, `{"traceId": "trace-1"}`)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200; body: %s", w.Code, w.Body)
+	}
+	var resp AnalysisResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if resp.Data == nil || resp.Data.AnalysisSummary.OverallStatus != ai.StatusError {
+		t.Errorf("data = %+v, want overallStatus ERROR", resp.Data)
+	}
+	if len(resp.Data.Findings) != 1 || resp.Data.Findings[0].ObservationIDs[0] != "obs-1" {
+		t.Errorf("findings = %+v, want one finding for obs-1", resp.Data.Findings)
+	}
+	if resp.Metadata.Language != defaultLanguage {
+		t.Errorf("metadata.language = %q, want %q", resp.Metadata.Language, defaultLanguage)
+	}
+	if model.calls != 1 {
+		t.Errorf("model calls = %d, want 1", model.calls)
+	}
+}
+
+func TestHandleAnalyzeErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		fetchErr error
+		status   int
+		code     apperr.Code
+	}{
+		{
+			name:     "trace not found",
+			body:     `{"traceId": "missing"}`,
+			fetchErr: &langfuse.APIError{StatusCode: http.StatusNotFound, Message: "trace not found"},
+			status:   http.StatusNotFound,
+			code:     apperr.CodeTraceNotFound,
+		},
+		{
+			name:     "wrapped not found",
+			body:     `{"traceId": "missing"}`,
+			fetchErr: fmt.Errorf("получение трейса: %w", &langfuse.APIError{StatusCode: http.StatusNotFound}),
+			status:   http.StatusNotFound,
+			code:     apperr.CodeTraceNotFound,
+		},
+		{
+			name:     "unauthorized",
+			body:     `{"traceId": "trace-1"}`,
+			fetchErr: &langfuse.APIError{StatusCode: http.StatusUnauthorized, Message: "invalid credentials"},
+			status:   http.StatusBadGateway,
+			code:     apperr.CodeAuthFailed,
+		},
+		{
+			name:     "forbidden",
+			body:     `{"traceId": "trace-1"}`,
+			fetchErr: &langfuse.APIError{StatusCode: http.StatusForbidden},
+			status:   http.StatusBadGateway,
+			code:     apperr.CodeAuthFailed,
+		},
+		{
+			name:   "missing traceId",
+			body:   `{}`,
+			status: http.StatusBadRequest,
+			code:   apperr.CodeInvalidRequest,
+		},
+		{
+			name:   "unsupported language",
+			body:   `{"traceId": "trace-1", "language": "xx"}`,
+			status: http.StatusBadRequest,
+			code:   apperr.CodeUnsupportedLanguage,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.fetchErr != nil {
+				// Проверка самих фейков: ошибки распознаются как ошибки клиента Langfuse
+				if tt.status == http.StatusNotFound && !errors.Is(tt.fetchErr, langfuse.ErrNotFound) {
+					t.Fatalf("fetch error does not match langfuse.ErrNotFound")
+				}
+				if tt.code == apperr.CodeAuthFailed && !errors.Is(tt.fetchErr, langfuse.ErrUnauthorized) {
+					t.Fatalf("fetch error does not match langfuse.ErrUnauthorized")
+				}
+			}
+
+			model := &fakeAI{answer: validReport}
+			w := serveAnalyze(t, &fakeFetcher{trace: testTrace(), err: tt.fetchErr}, model, tt.body)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d; body: %s", w.Code, tt.status, w.Body)
+			}
+			var resp ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if resp.Code != tt.code {
+				t.Errorf("code = %q, want %q", resp.Code, tt.code)
+			}
+			if resp.Error == "" || resp.Error != resp.Message {
+				t.Errorf("error = %q, message = %q: want the same non-empty text", resp.Error, resp.Message)
+			}
+			if resp.RequestID == "" {
+				t.Error("requestId is empty")
+			}
+			if model.calls != 0 {
+				t.Errorf("model calls = %d, want 0", model.calls)
+			}
+		})
+	}
+}
+
+func TestHandleAnalyzeBatchOutlivesWriteTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	prevFetcher, prevAnalyzer := langfuseClient, analyzer
+	t.Cleanup(func() { langfuseClient, analyzer = prevFetcher, prevAnalyzer })
+	langfuseClient = &fakeFetcher{trace: testTrace()}
+	analyzer = ai.NewAnalyzer(&fakeAI{answer: validReport, delay: 300 * time.Millisecond}, ai.DefaultPrompts(), 2)
+
+	router := gin.New()
+	router.Use(requestID())
+	router.POST("/analyze/batch", handleAnalyzeBatch)
+
+	// Пакет анализируется дольше таймаута записи сервера
+	server := httptest.NewUnstartedServer(router)
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Start()
+	t.Cleanup(server.Close)
+
+	resp, err := http.Post(server.URL+"/analyze/batch", "application/json", strings.NewReader(`{"traceIds": ["trace-1"]}`))
+	if err != nil {
+		t.Fatalf("POST /analyze/batch: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Results []BatchItem  `json:"results"`
+		Summary BatchSummary `json:"summary"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("batch response cut by the write timeout: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || body.Summary.Succeeded != 1 || len(body.Results) != 1 {
+		t.Errorf("status = %d, summary = %+v; want one succeeded trace", resp.StatusCode, body.Summary)
+	}
+}
+
+func TestFailedJobKeepsCreatingRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	prevFetcher, prevAnalyzer, prevManager := langfuseClient, analyzer, jobManager
+	langfuseClient = &fakeFetcher{err: &langfuse.APIError{StatusCode: http.StatusNotFound}}
+	analyzer = ai.NewAnalyzer(&fakeAI{answer: validReport}, ai.DefaultPrompts(), 2)
+	jobManager = jobs.NewManager(jobs.Config{Workers: 1, QueueSize: 1})
+	t.Cleanup(func() {
+		jobManager.Shutdown(context.Background())
+		langfuseClient, analyzer, jobManager = prevFetcher, prevAnalyzer, prevManager
+	})
+
+	router := gin.New()
+	router.Use(requestID())
+	router.POST("/jobs", handleCreateJob)
+	router.GET("/jobs/:id", handleGetJob)
+
+	serve := func(method, path, body, id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(requestIDHeader, id)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve(http.MethodPost, "/jobs", `{"traceId": "missing"}`, "create-1")
+	var created JobResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || w.Code != http.StatusAccepted {
+		t.Fatalf("POST /jobs = %d %s, %v", w.Code, w.Body, err)
+	}
+
+	var job JobResponse
+	for i := 0; ; i++ {
+		w = serve(http.MethodGet, "/jobs/"+created.JobID, "", fmt.Sprintf("poll-%d", i))
+		if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		if job.Status.Done() || i > 1000 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if job.Status != jobs.StatusFailed || job.Error == nil {
+		t.Fatalf("job = %+v, want failed with error", job)
+	}
+	if job.Error.RequestID != "create-1" {
+		t.Errorf("error.requestId = %q, want the POST /jobs request ID create-1", job.Error.RequestID)
+	}
+	if job.Error.Code != apperr.CodeTraceNotFound || job.Error.Status != http.StatusNotFound {
+		t.Errorf("error = %+v, want TRACE_NOT_FOUND with status 404", job.Error)
+	}
+}
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"langfuse-analyzer-backend/ai"
+)
+
+// AIClient - AIClient, который учитывает каждый запрос к провайдеру:
+// длительность, запросы в работе, токены и ошибки. Оборачивает клиента
+// провайдера напрямую, под повторами и предохранителем, поэтому каждая
+// попытка учитывается отдельно, а отказы предохранителя - нет.
+type AIClient struct {
+	provider string
+	model    string
+	client   ai.AIClient
+}
+
+// InstrumentAI оборачивает клиента провайдера provider с моделью model
+func InstrumentAI(provider ai.ProviderType, model string, client ai.AIClient) *AIClient {
+	return &AIClient{provider: string(provider), model: model, client: client}
+}
+
+// AnalyzeTrace - анализ с учетом в метриках
+func (c *AIClient) AnalyzeTrace(ctx context.Context, req *ai.AnalysisRequest) (string, error) {
+	return c.observe(ctx, func(ctx context.Context) (string, error) {
+		return c.client.AnalyzeTrace(ctx, req)
+	})
+}
+
+// AnalyzeTraceStream - потоковый анализ с учетом в метриках
+func (c *AIClient) AnalyzeTraceStream(ctx context.Context, req *ai.AnalysisRequest, onToken func(string)) (string, error) {
+	return c.observe(ctx, func(ctx context.Context) (string, error) {
+		return c.client.AnalyzeTraceStream(ctx, req, onToken)
+	})
+}
+
+// Check проверяет провайдера вложенного клиента
+func (c *AIClient) Check(ctx context.Context) error {
+	return ai.Check(ctx, c.client)
+}
+
+// Close закрывает вложенного клиента
+func (c *AIClient) Close() error {
+	return ai.Close(c.client)
+}
+
+func (c *AIClient) observe(ctx context.Context, call func(context.Context) (string, error)) (string, error) {
+	inFlight := llmInFlight.WithLabelValues(c.provider, c.model)
+	inFlight.Inc()
+	defer inFlight.Dec()
+
+	ctx, usage := ai.WithUsage(ctx)
+	started := time.Now()
+	content, err := call(ctx)
+
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+		llmErrors.WithLabelValues(c.provider, c.model, errorStatus(err)).Inc()
+	}
+	llmDuration.WithLabelValues(c.provider, c.model, outcome).Observe(time.Since(started).Seconds())
+	if usage.PromptTokens > 0 {
+		llmTokens.WithLabelValues(c.provider, c.model, "prompt").Add(float64(usage.PromptTokens))
+	}
+	if usage.CompletionTokens > 0 {
+		llmTokens.WithLabelValues(c.provider, c.model, "completion").Add(float64(usage.CompletionTokens))
+	}
+	return content, err
+}
+
+// errorStatus - метка ошибки: HTTP статус провайдера или причина, по
+// которой ответа нет
+func errorStatus(err error) string {
+	var aiErr *ai.AIError
+	switch {
+	case errors.As(err, &aiErr):
+		return strconv.Itoa(aiErr.StatusCode)
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	default:
+		return "other"
+	}
+}
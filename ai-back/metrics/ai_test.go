@@ -0,0 +1,164 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"langfuse-analyzer-backend/ai"
+	"langfuse-analyzer-backend/langfuse"
+)
+
+// fakeAI - клиент провайдера, который возвращает заданный результат
+type fakeAI struct {
+	content string
+	err     error
+}
+
+func (f fakeAI) AnalyzeTrace(context.Context, *ai.AnalysisRequest) (string, error) {
+	return f.content, f.err
+}
+
+func (f fakeAI) AnalyzeTraceStream(ctx context.Context, req *ai.AnalysisRequest, onToken func(string)) (string, error) {
+	return f.AnalyzeTrace(ctx, req)
+}
+
+// llmRegistry - отдельный реестр с метриками AI провайдеров, чтобы тест
+// видел только их. Каждый тест использует свою модель: метрики общие для пакета.
+func llmRegistry(t *testing.T) *prometheus.Registry {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(llmDuration, llmInFlight, llmTokens, llmErrors)
+	return reg
+}
+
+func testRequest() *ai.AnalysisRequest {
+	return &ai.AnalysisRequest{Trace: &langfuse.Trace{ID: "trace-1"}, Language: "ru"}
+}
+
+func TestInstrumentAILabels(t *testing.T) {
+	reg := llmRegistry(t)
+	const model = "labels-model"
+
+	ok := InstrumentAI(ai.ProviderOpenRouter, model, fakeAI{content: "{}"})
+	if _, err := ok.AnalyzeTrace(context.Background(), testRequest()); err != nil {
+		t.Fatalf("AnalyzeTrace() error = %v", err)
+	}
+	failing := InstrumentAI(ai.ProviderAnthropic, model, fakeAI{err: &ai.AIError{StatusCode: http.StatusTooManyRequests, Message: "rate limit"}})
+	for i := 0; i < 2; i++ {
+		if _, err := failing.AnalyzeTraceStream(context.Background(), testRequest(), func(string) {}); err == nil {
+			t.Fatal("AnalyzeTraceStream() error = nil, want the provider error")
+		}
+	}
+	canceled := InstrumentAI(ai.ProviderAnthropic, model, fakeAI{err: fmt.Errorf("stream: %w", context.Canceled)})
+	canceled.AnalyzeTrace(context.Background(), testRequest())
+
+	want := fmt.Sprintf(`
+# HELP analyzer_llm_errors_total %s
+# TYPE analyzer_llm_errors_total counter
+analyzer_llm_errors_total{model=%[2]q,provider="anthropic",status_code="429"} 2
+analyzer_llm_errors_total{model=%[2]q,provider="anthropic",status_code="canceled"} 1
+`, helpText(t, reg, "analyzer_llm_errors_total"), model)
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "analyzer_llm_errors_total"); err != nil {
+		t.Error(err)
+	}
+
+	counts := []struct {
+		provider ai.ProviderType
+		outcome  string
+		want     int
+	}{
+		{ai.ProviderOpenRouter, "ok", 1},
+		{ai.ProviderOpenRouter, "error", 0},
+		{ai.ProviderAnthropic, "ok", 0},
+		{ai.ProviderAnthropic, "error", 3},
+	}
+	for _, tt := range counts {
+		if got := histogramCount(t, reg, string(tt.provider), model, tt.outcome); got != tt.want {
+			t.Errorf("llm_request_duration_seconds{provider=%q,outcome=%q} count = %d, want %d", tt.provider, tt.outcome, got, tt.want)
+		}
+	}
+	if got := testutil.ToFloat64(llmInFlight.WithLabelValues(string(ai.ProviderAnthropic), model)); got != 0 {
+		t.Errorf("llm_requests_in_flight = %v after the calls returned, want 0", got)
+	}
+}
+
+func TestInstrumentAITokens(t *testing.T) {
+	reg := llmRegistry(t)
+	const model = "tokens-model"
+
+	// Провайдер сообщает расход токенов в ответе; клиент записывает его
+	// через recordUsage в контекст, созданный InstrumentAI
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"{}"}}],"usage":{"prompt_tokens":120,"completion_tokens":7,"total_tokens":127}}`)
+	}))
+	defer server.Close()
+	client := InstrumentAI(ai.ProviderOpenRouter, model, ai.NewOpenAICompatibleClient("", server.URL, model, 100, nil, ai.Capabilities{}))
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.AnalyzeTrace(context.Background(), testRequest()); err != nil {
+			t.Fatalf("AnalyzeTrace() error = %v", err)
+		}
+	}
+	// Ошибка без расхода токенов не добавляет рядов
+	InstrumentAI(ai.ProviderOpenRouter, model, fakeAI{err: errors.New("boom")}).AnalyzeTrace(context.Background(), testRequest())
+
+	want := fmt.Sprintf(`
+# HELP analyzer_llm_tokens_total %s
+# TYPE analyzer_llm_tokens_total counter
+analyzer_llm_tokens_total{model=%[2]q,provider="openrouter",type="completion"} 14
+analyzer_llm_tokens_total{model=%[2]q,provider="openrouter",type="prompt"} 240
+`, helpText(t, reg, "analyzer_llm_tokens_total"), model)
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "analyzer_llm_tokens_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+// helpText возвращает описание метрики name из реестра
+func helpText(t *testing.T, reg *prometheus.Registry, name string) string {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() == name {
+			return family.GetHelp()
+		}
+	}
+	t.Fatalf("metric %s not found", name)
+	return ""
+}
+
+// histogramCount возвращает число наблюдений llm_request_duration_seconds
+// с метками provider, model и outcome
+func histogramCount(t *testing.T, reg *prometheus.Registry, provider, model, outcome string) int {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "analyzer_llm_request_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["provider"] == provider && labels["model"] == model && labels["outcome"] == outcome {
+				return int(metric.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	return 0
+}
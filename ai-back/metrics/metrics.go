@@ -0,0 +1,126 @@
+// Package metrics - метрики сервиса в формате Prometheus: HTTP запросы,
+// запросы к Langfuse и к AI провайдерам, расход токенов, анализы в работе.
+// Все метрики регистрируются в собственном реестре и отдаются Handler.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "analyzer"
+
+// Границы гистограмм длительности, секунды: от быстрых ответов API до
+// анализа большого трейса локальной моделью
+var durationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+var registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP запросы к сервису по маршруту, методу и статусу ответа.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Длительность обработки HTTP запросов к сервису.",
+		Buckets:   durationBuckets,
+	}, []string{"route", "method", "status"})
+
+	langfuseDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "langfuse_request_duration_seconds",
+		Help:      "Длительность одной попытки запроса к Langfuse; status 0 - ответ не получен.",
+		Buckets:   durationBuckets,
+	}, []string{"operation", "status"})
+
+	langfuseRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "langfuse_retries_total",
+		Help:      "Повторы запросов к Langfuse.",
+	}, []string{"operation"})
+
+	llmDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "llm_request_duration_seconds",
+		Help:      "Длительность одного запроса к AI провайдеру; outcome - ok или error.",
+		Buckets:   durationBuckets,
+	}, []string{"provider", "model", "outcome"})
+
+	llmInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "llm_requests_in_flight",
+		Help:      "Запросы к AI провайдеру, ожидающие ответа.",
+	}, []string{"provider", "model"})
+
+	llmTokens = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "llm_tokens_total",
+		Help:      "Токены по данным провайдера; type - prompt или completion.",
+	}, []string{"provider", "model", "type"})
+
+	llmErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "llm_errors_total",
+		Help:      "Ошибки запросов к AI провайдеру по HTTP статусу ответа (AIError.StatusCode); timeout, canceled или other - ответа нет.",
+	}, []string{"provider", "model", "status_code"})
+
+	analysesInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "analyses_in_flight",
+		Help:      "Анализы в работе; kind - trace, stream или session.",
+	}, []string{"kind"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration,
+		langfuseDuration, langfuseRetries,
+		llmDuration, llmInFlight, llmTokens, llmErrors,
+		analysesInFlight,
+	)
+}
+
+// Handler отдает метрики в формате Prometheus
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveHTTP учитывает обработанный HTTP запрос. route - шаблон
+// маршрута (/jobs/:id), а не путь, чтобы число рядов не росло.
+func ObserveHTTP(route, method string, status int, duration time.Duration) {
+	code := strconv.Itoa(status)
+	httpRequests.WithLabelValues(route, method, code).Inc()
+	httpDuration.WithLabelValues(route, method, code).Observe(duration.Seconds())
+}
+
+// TrackAnalysis учитывает анализ вида kind в работе; вызовите
+// возвращенную функцию, когда анализ завершится
+func TrackAnalysis(kind string) func() {
+	gauge := analysesInFlight.WithLabelValues(kind)
+	gauge.Inc()
+	return gauge.Dec
+}
+
+// Langfuse - получатель событий клиента Langfuse (langfuse.Observer)
+type Langfuse struct{}
+
+// ObserveRequest учитывает попытку запроса к Langfuse
+func (Langfuse) ObserveRequest(operation string, status int, duration time.Duration) {
+	langfuseDuration.WithLabelValues(operation, strconv.Itoa(status)).Observe(duration.Seconds())
+}
+
+// ObserveRetry учитывает повтор запроса к Langfuse
+func (Langfuse) ObserveRetry(operation string) {
+	langfuseRetries.WithLabelValues(operation).Inc()
+}
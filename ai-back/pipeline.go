@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"langfuse-analyzer-backend/ai"
+	"langfuse-analyzer-backend/apperr"
+	"langfuse-analyzer-backend/compact"
+	"langfuse-analyzer-backend/heuristics"
+	"langfuse-analyzer-backend/langfuse"
+	"langfuse-analyzer-backend/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fetchError помечает ошибку, возникшую при получении данных из Langfuse
+type fetchError struct {
+	resource string // "trace" или "session"
+	err      error
+}
+
+func (e *fetchError) Error() string { return e.err.Error() }
+func (e *fetchError) Unwrap() error { return e.err }
+
+// AnalysisResponse - тело успешного ответа анализа
+type AnalysisResponse struct {
+	Data       *ai.AnalysisResult   `json:"data"`       // проверенный отчет модели
+	Heuristics []heuristics.Finding `json:"heuristics"` // факты, вычисленные без модели
+	Compaction *compact.Report      `json:"compaction"` // что было сокращено перед отправкой модели
+	Metadata   AnalysisMetadata     `json:"metadata"`
+}
+
+// AnalysisMetadata - сведения о том, как был получен отчет
+type AnalysisMetadata struct {
+	PromptVersion  string    `json:"promptVersion"`
+	Language       string    `json:"language"`
+	AnalyzedAt     time.Time `json:"analyzedAt"`
+	ProcessingTime float64   `json:"processingTime"`   // секунды
+	Chunks         int       `json:"chunks,omitempty"` // на сколько частей был разбит трейс
+	// Providers - какие провайдеры ответили ("provider/model"). Больше
+	// одного, если часть запросов ушла резервным провайдерам.
+	Providers []string `json:"providers,omitempty"`
+}
+
+// newMetadata заполняет метаданные анализа, начатого в started. ctx -
+// контекст анализа, созданный ai.WithAnswered.
+func newMetadata(ctx context.Context, started time.Time, language string) AnalysisMetadata {
+	return AnalysisMetadata{
+		PromptVersion:  analyzer.PromptVersion(),
+		Language:       language,
+		AnalyzedAt:     time.Now().UTC(),
+		ProcessingTime: time.Since(started).Seconds(),
+		Providers:      ai.AnsweredBy(ctx),
+	}
+}
+
+// heuristicThresholds - пороги эвристик, применяемые перед анализом
+var heuristicThresholds = heuristics.DefaultThresholds()
+
+// compactBudget - ограничения размера трейса, отправляемого модели; задаются в main
+var compactBudget = compact.DefaultBudget()
+
+// Анализ по частям для трейсов, которые не помещаются в бюджет; задается в main
+var (
+	chunkMaxObservations = 200 // наблюдений в одной части, не больше
+	chunkMaxParts        = 20  // частей на трейс, не больше
+	chunkConcurrency     = 2   // частей анализируется одновременно
+)
+
+// defaultLanguage - язык отчета, если он не указан в запросе; задается в main
+var defaultLanguage = ai.DefaultLanguage
+
+// resolveLanguage проверяет язык из запроса; пустой заменяется языком по умолчанию
+func resolveLanguage(requested string) (string, error) {
+	language, err := ai.NormalizeLanguage(requested)
+	if err != nil {
+		return "", err
+	}
+	if language == "" {
+		language = defaultLanguage
+	}
+	return language, nil
+}
+
+// languageError - ошибка запроса с неподдерживаемым языком; в details -
+// список поддерживаемых языков
+func languageError(err error) *apperr.Error {
+	return &apperr.Error{
+		Code:    apperr.CodeUnsupportedLanguage,
+		Message: err.Error(),
+		Details: gin.H{"supported": ai.SupportedLanguages()},
+		Err:     err,
+	}
+}
+
+// runAnalysis получает трейс из Langfuse, прогоняет эвристики и анализирует
+// трейс через AI. language - уже проверенный resolveLanguage код языка отчета.
+func runAnalysis(ctx context.Context, traceID, language string) (*AnalysisResponse, error) {
+	started := time.Now()
+	defer metrics.TrackAnalysis("trace")()
+	ctx = ai.WithAnswered(ctx)
+	log.Println("🔄 ШАГ 1: Получение данных трейса из Langfuse")
+
+	trace, err := langfuseClient.GetTrace(ctx, traceID)
+	if err != nil {
+		log.Printf("❌ Ошибка получения трейса: %v", err)
+		return nil, &fetchError{resource: "trace", err: err}
+	}
+
+	log.Printf("✅ Трейс получен: %s, наблюдений: %d, latency: %.2fs, стоимость: $%.4f",
+		trace.Name, len(trace.Observations), trace.Latency, trace.TotalCost)
+
+	findings := runHeuristics(trace, language)
+	plan := planTrace(trace)
+
+	log.Println("----------------------------------------------")
+	log.Println("🤖 ШАГ 2: Отправка на анализ AI")
+
+	var result *ai.AnalysisResult
+	aiReq := &ai.AnalysisRequest{Trace: plan.trace, Facts: findings, Language: language}
+	if plan.chunked() {
+		aiReq.Trace = trace
+		result, err = analyzer.AnalyzeChunks(ctx, aiReq, plan.parts, chunkConcurrency, nil)
+	} else {
+		result, err = analyzer.Analyze(ctx, aiReq)
+	}
+	if err != nil {
+		log.Printf("❌ Ошибка анализа AI: %v", err)
+		return nil, err
+	}
+
+	log.Printf("✅ AI анализ завершён: %s / %s", result.AnalysisSummary.OverallStatus, result.DetailedAnalysis.AnomalyType)
+	return plan.response(ctx, result, findings, started, language), nil
+}
+
+// tracePlan - как трейс отправляется модели: целиком (trace) или по частям (parts)
+type tracePlan struct {
+	trace  *langfuse.Trace
+	parts  []*langfuse.Trace
+	report *compact.Report
+}
+
+func (p *tracePlan) chunked() bool {
+	return len(p.parts) > 1
+}
+
+// response собирает ответ анализа по плану
+func (p *tracePlan) response(ctx context.Context, result *ai.AnalysisResult, findings []heuristics.Finding, started time.Time, language string) *AnalysisResponse {
+	resp := &AnalysisResponse{
+		Data:       result,
+		Heuristics: findings,
+		Compaction: p.report,
+		Metadata:   newMetadata(ctx, started, language),
+	}
+	if p.chunked() {
+		resp.Metadata.Chunks = len(p.parts)
+	}
+	return resp
+}
+
+// planTrace сокращает трейс до бюджета. Если для этого пришлось удалять
+// поля целиком, трейс делится на части по поддеревьям наблюдений, и каждая
+// часть сокращается отдельно: так модель видит данные всех наблюдений.
+func planTrace(trace *langfuse.Trace) *tracePlan {
+	compacted, report := compactTrace(trace, compactBudget)
+	if (report.DroppedFields == 0 && !report.OverBudget) || compactBudget.MaxTokens <= 0 {
+		return &tracePlan{trace: compacted, report: report}
+	}
+
+	// Частей столько, во сколько раз трейс больше бюджета
+	parts := (report.OriginalTokens + compactBudget.MaxTokens - 1) / compactBudget.MaxTokens
+	if parts > chunkMaxParts {
+		parts = chunkMaxParts
+	}
+	perPart := (len(trace.Observations) + parts - 1) / parts
+	if perPart > chunkMaxObservations {
+		perPart = chunkMaxObservations
+	}
+
+	chunks := compact.Split(trace, perPart)
+	if len(chunks) <= 1 {
+		return &tracePlan{trace: compacted, report: report}
+	}
+
+	log.Printf("🧩 Трейс %s не помещается в бюджет, анализ по частям: %d", trace.ID, len(chunks))
+	plan := &tracePlan{trace: trace, report: &compact.Report{}}
+	for _, chunk := range chunks {
+		part, partReport := compactTrace(chunk, compactBudget)
+		plan.parts = append(plan.parts, part)
+		plan.report.Merge(partReport)
+	}
+	return plan
+}
+
+// runHeuristics прогоняет эвристики по трейсу, сообщения находок - на
+// языке отчета. Всегда возвращает не-nil срез, чтобы в JSON было []
+// вместо null.
+func runHeuristics(trace *langfuse.Trace, language string) []heuristics.Finding {
+	findings := heuristics.Analyze(trace, heuristicThresholds, language)
+	if findings == nil {
+		findings = []heuristics.Finding{}
+	}
+	log.Printf("🧮 Эвристики: найдено фактов: %d", len(findings))
+	return findings
+}
+
+// compactTrace укладывает трейс в бюджет токенов. Эвристики работают
+// с исходным трейсом, модели отправляется сокращенный.
+func compactTrace(trace *langfuse.Trace, budget compact.Budget) (*langfuse.Trace, *compact.Report) {
+	compacted, report := compact.Trace(trace, budget)
+	if report.Compacted() {
+		log.Printf("✂️  Трейс %s сокращен: ~%d → ~%d токенов (base64: %d, повторов: %d, обрезано: %d, удалено: %d)",
+			trace.ID, report.OriginalTokens, report.CompactedTokens, report.DroppedBlobs,
+			report.CollapsedObservations, report.TruncatedFields, report.DroppedFields)
+	}
+	if report.OverBudget {
+		log.Printf("⚠️  Трейс %s не уложился в бюджет %d токенов", trace.ID, report.Budget)
+	}
+	return compacted, report
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"cmp"
+	"log"
+	"net/http"
+	"strings"
+
+	"langfuse-analyzer-backend/ai"
+	"langfuse-analyzer-backend/config"
+	"langfuse-analyzer-backend/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// providerBreakers - предохранители провайдеров цепочки, для /providers/status
+var providerBreakers []*ai.CircuitBreaker
+
+// aiProviders - провайдеры цепочки в порядке обращения, для /healthz и /readyz
+var aiProviders []ai.FallbackProvider
+
+// providerConfig собирает настройки звена цепочки провайдеров. Модель
+// звена, если задана, заменяет модель из настроек провайдера - так одно
+// звено ai.fallback может использовать другую модель того же провайдера.
+// Обязательные настройки уже проверены config.Validate.
+func providerConfig(cfg *config.Config, entry config.ChainEntry) ai.Config {
+	p := cfg.Providers
+	aiCfg := ai.Config{MaxTokens: cfg.AI.MaxTokens}
+
+	switch entry.Provider {
+	case "ollama":
+		// Для Ollama API ключ не нужен
+		aiCfg.Provider = ai.ProviderOllama
+		aiCfg.BaseURL = p.Ollama.BaseURL
+		aiCfg.Model = cmp.Or(entry.Model, p.Ollama.Model)
+		aiCfg.Timeout = p.Ollama.Timeout
+		log.Println("🤖 Используется AI провайдер: OLLAMA")
+		log.Printf("⏱️  Таймаут Ollama: %s", aiCfg.Timeout)
+		log.Printf("📍 Ollama URL: %s", aiCfg.BaseURL)
+		log.Printf("🧠 Модель Ollama: %s", aiCfg.Model)
+
+	case "anthropic":
+		// prefill - ответ начинается с "{", tool - отчет передается как аргументы инструмента
+		aiCfg.Provider = ai.ProviderAnthropic
+		aiCfg.APIKey = p.Anthropic.APIKey
+		aiCfg.BaseURL = p.Anthropic.BaseURL
+		aiCfg.Model = cmp.Or(entry.Model, p.Anthropic.Model)
+		aiCfg.AnthropicJSONMode = p.Anthropic.JSONMode
+		aiCfg.Timeout = p.Anthropic.Timeout
+		log.Println("🤖 Используется AI провайдер: ANTHROPIC")
+		log.Printf("⏱️  Таймаут Anthropic: %s", aiCfg.Timeout)
+		log.Printf("🧠 Модель Anthropic: %s (JSON: %s)", aiCfg.Model, aiCfg.AnthropicJSONMode)
+
+	case "gemini":
+		aiCfg.Provider = ai.ProviderGemini
+		aiCfg.APIKey = p.Gemini.APIKey
+		aiCfg.BaseURL = p.Gemini.BaseURL
+		aiCfg.Model = cmp.Or(entry.Model, p.Gemini.Model)
+		aiCfg.Timeout = p.Gemini.Timeout
+		log.Println("🤖 Используется AI провайдер: GEMINI")
+		log.Printf("⏱️  Таймаут Gemini: %s", aiCfg.Timeout)
+		log.Printf("🧠 Модель Gemini: %s", aiCfg.Model)
+
+	case "azure":
+		// Данные трейсов уходят только в deployment клиента в Azure
+		aiCfg.Provider = ai.ProviderAzure
+		aiCfg.APIKey = p.Azure.APIKey
+		aiCfg.BaseURL = p.Azure.Endpoint
+		aiCfg.Model = cmp.Or(entry.Model, p.Azure.Deployment)
+		aiCfg.AzureAPIVersion = p.Azure.APIVersion
+		log.Println("🤖 Используется AI провайдер: AZURE OPENAI")
+		log.Printf("📍 Azure OpenAI endpoint: %s", aiCfg.BaseURL)
+		log.Printf("🧠 Deployment Azure OpenAI: %s (api-version %s)", aiCfg.Model, aiCfg.AzureAPIVersion)
+
+	case "openai-compatible":
+		// vLLM, llama.cpp server, LM Studio и другие серверы с OpenAI API.
+		// Ключ нужен не всем: без него заголовок Authorization не отправляется.
+		// Не все серверы и шаблоны чата поддерживают JSON mode, роль system
+		// и stream_options.
+		aiCfg.Provider = ai.ProviderOpenAICompatible
+		aiCfg.APIKey = p.OpenAICompatible.APIKey
+		aiCfg.BaseURL = p.OpenAICompatible.BaseURL
+		aiCfg.Model = cmp.Or(entry.Model, p.OpenAICompatible.Model)
+		aiCfg.ExtraHeaders = p.OpenAICompatible.Headers
+		aiCfg.Capabilities = ai.Capabilities{
+			JSONMode:    p.OpenAICompatible.JSONMode,
+			SystemRole:  p.OpenAICompatible.SystemRole,
+			StreamUsage: p.OpenAICompatible.StreamUsage,
+		}
+		log.Println("🤖 Используется AI провайдер: OPENAI-COMPATIBLE")
+		log.Printf("📍 OpenAI-совместимый сервер: %s", aiCfg.BaseURL)
+		log.Printf("🧠 Модель: %s (JSON mode: %t, роль system: %t, токены в потоке: %t, доп. заголовков: %d)",
+			aiCfg.Model, aiCfg.Capabilities.JSONMode, aiCfg.Capabilities.SystemRole, aiCfg.Capabilities.StreamUsage, len(aiCfg.ExtraHeaders))
+
+	default:
+		aiCfg.Provider = ai.ProviderOpenRouter
+		aiCfg.APIKey = p.OpenRouter.APIKey
+		aiCfg.BaseURL = p.OpenRouter.BaseURL
+		aiCfg.Model = cmp.Or(entry.Model, p.OpenRouter.Model)
+		log.Println("🤖 Используется AI провайдер: OPENROUTER")
+		log.Printf("🧠 Модель OpenRouter: %s", aiCfg.Model)
+	}
+	return aiCfg
+}
+
+// newAIClient создает цепочку провайдеров: основной из ai.provider и
+// резервные из ai.fallback. Если основной провайдер недоступен, запрос
+// уходит следующему.
+//
+// Порядок обертки звена снаружи внутрь: предохранитель (если
+// ai.breakerFailures > 0) → повторы при 429 (ai.retry*) → метрики →
+// клиент провайдера. Метрики учитывают каждую попытку запроса, включая
+// повторы. Повторы есть только у последнего звена: пока после провайдера
+// есть резервный, на 429 запрос сразу уходит резервному, а не ждет
+// Retry-After.
+func newAIClient(cfg *config.Config) *ai.FallbackClient {
+	retryCfg := ai.DefaultRetryConfig()
+	retryCfg.MaxAttempts = cfg.AI.RetryMaxAttempts
+	retryCfg.Budget = cfg.AI.RetryBudget
+	retryEnabled := retryCfg.MaxAttempts > 1 && retryCfg.Budget > 0
+	if retryEnabled {
+		log.Printf("⏳ Повторы при 429 у последнего провайдера цепочки: до %d попыток, ожидание до %s", retryCfg.MaxAttempts, retryCfg.Budget)
+	}
+
+	breakerCfg := ai.BreakerConfig{
+		FailureThreshold: cfg.AI.BreakerFailures,
+		OpenTimeout:      cfg.AI.BreakerOpen,
+	}
+	if breakerCfg.FailureThreshold > 0 {
+		log.Printf("🔌 Предохранитель провайдеров: %d ошибок подряд, пауза %s", breakerCfg.FailureThreshold, breakerCfg.OpenTimeout)
+	}
+
+	chain := cfg.AI.Chain()
+	providers := make([]ai.FallbackProvider, 0, len(chain))
+	for i, entry := range chain {
+		aiCfg := providerConfig(cfg, entry)
+		p := ai.FallbackProvider{
+			Provider: aiCfg.Provider,
+			Model:    aiCfg.Model,
+			Client:   metrics.InstrumentAI(aiCfg.Provider, aiCfg.Model, ai.NewAIClient(aiCfg)),
+		}
+		if retryEnabled && i == len(chain)-1 {
+			p.Client = ai.NewRetryClient(p.Name(), p.Client, retryCfg)
+		}
+		if breakerCfg.FailureThreshold > 0 {
+			breaker := ai.NewCircuitBreaker(p.Name(), p.Client, breakerCfg)
+			providerBreakers = append(providerBreakers, breaker)
+			p.Client = breaker
+		}
+		providers = append(providers, p)
+	}
+
+	aiProviders = providers
+
+	if len(providers) > 1 {
+		names := make([]string, len(providers))
+		for i, p := range providers {
+			names[i] = p.Name()
+		}
+		log.Printf("🔀 Цепочка AI провайдеров: %s", strings.Join(names, " → "))
+	}
+	return ai.NewFallbackClient(providers)
+}
+
+// handleProviderStatus отдает состояние предохранителей провайдеров в
+// порядке цепочки: closed - провайдер работает, open - запросы к нему
+// отклоняются без обращения, half_open - идет пробный запрос
+func handleProviderStatus(c *gin.Context) {
+	statuses := make([]ai.BreakerStatus, 0, len(providerBreakers))
+	for _, b := range providerBreakers {
+		statuses = append(statuses, b.Status())
+	}
+	c.JSON(http.StatusOK, gin.H{"providers": statuses})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"langfuse-analyzer-backend/ai"
+	"langfuse-analyzer-backend/config"
+)
+
+func TestNewAIClientRetriesOnlyLastProvider(t *testing.T) {
+	prevProviders, prevBreakers := aiProviders, providerBreakers
+	t.Cleanup(func() { aiProviders, providerBreakers = prevProviders, prevBreakers })
+
+	tests := []struct {
+		name     string
+		fallback []string
+		retried  []bool
+	}{
+		{"single provider", nil, []bool{true}},
+		{"chain", []string{"ollama", "openrouter:other-model"}, []bool{false, false, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.AI.Provider = "openrouter"
+			cfg.AI.Fallback = tt.fallback
+			cfg.AI.BreakerFailures = 0 // без предохранителя звено - сам RetryClient
+			cfg.Providers.OpenRouter.APIKey = "test-key"
+
+			newAIClient(cfg)
+
+			if len(aiProviders) != len(tt.retried) {
+				t.Fatalf("providers = %d, want %d", len(aiProviders), len(tt.retried))
+			}
+			for i, p := range aiProviders {
+				_, retried := p.Client.(*ai.RetryClient)
+				if retried != tt.retried[i] {
+					t.Errorf("provider %d (%s): retried = %t, want %t", i, p.Name(), retried, tt.retried[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"langfuse-analyzer-backend/ai"
+	"langfuse-analyzer-backend/config"
+)
+
+// errShuttingDown - причина отмены запросов, не завершившихся к остановке сервера
+var errShuttingDown = errors.New("сервер останавливается")
+
+// cancelGrace - сколько ждать обработчики после отмены их контекстов:
+// им нужно время, чтобы отправить клиенту ответ с ошибкой
+const cancelGrace = 5 * time.Second
+
+// serve запускает HTTP сервер и работает до SIGINT или SIGTERM. При
+// остановке сервер перестает принимать соединения и ждет завершения
+// запросов и задач до cfg.ShutdownTimeout; оставшиеся отменяются через
+// контекст (анализ прерывается вместе с запросом к модели). Затем
+// закрываются клиенты AI провайдеров. Повторный сигнал завершает процесс
+// сразу.
+func serve(handler http.Handler, cfg config.Server) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Родитель контекстов всех запросов: отменяется, если запросы не
+	// успели завершиться за ShutdownTimeout
+	requestsCtx, cancelRequests := context.WithCancelCause(context.Background())
+	defer cancelRequests(nil)
+
+	server := &http.Server{
+		Addr:         cfg.Listen,
+		Handler:      handler,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+		BaseContext: func(net.Listener) context.Context {
+			return requestsCtx
+		},
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		log.Fatalf("❌ Ошибка HTTP сервера: %v", err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	log.Println("==============================================")
+	log.Printf("🛑 Остановка сервера: ждем завершения запросов и задач до %s", cfg.ShutdownTimeout)
+	log.Println("==============================================")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	jobsDone := make(chan error, 1)
+	go func() {
+		jobsDone <- jobManager.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("⚠️  Запросы не завершились за %s, отменяем их", cfg.ShutdownTimeout)
+		cancelRequests(errShuttingDown)
+
+		graceCtx, cancelGraceCtx := context.WithTimeout(context.Background(), cancelGrace)
+		if err := server.Shutdown(graceCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("⚠️  Закрываем оставшиеся соединения: %v", err)
+			server.Close()
+		}
+		cancelGraceCtx()
+	}
+
+	if err := <-jobsDone; err != nil {
+		log.Printf("⚠️  Задачи не завершились за %s и были отменены", cfg.ShutdownTimeout)
+	}
+
+	if err := ai.Close(aiClient); err != nil {
+		log.Printf("⚠️  Ошибка закрытия AI клиентов: %v", err)
+	}
+	log.Println("✅ Сервер остановлен")
+}
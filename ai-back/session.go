@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"langfuse-analyzer-backend/ai"
+	"langfuse-analyzer-backend/compact"
+	"langfuse-analyzer-backend/heuristics"
+	"langfuse-analyzer-backend/langfuse"
+	"langfuse-analyzer-backend/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SessionAnalyzeRequest - запрос на анализ сессии
+type SessionAnalyzeRequest struct {
+	SessionID string `json:"sessionId"`
+	Language  string `json:"language"`
+}
+
+// Ограничения анализа сессий, задаются в main
+var (
+	sessionMaxTraces    = 50
+	sessionFetchWorkers = 4
+)
+
+// handleAnalyzeSession анализирует все трейсы сессии как один диалог
+func handleAnalyzeSession(c *gin.Context) {
+	var req SessionAnalyzeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("❌ Ошибка парсинга JSON: %v", err)
+		c.JSON(errorResponse(c, invalidRequest("Некорректный JSON: "+err.Error())))
+		return
+	}
+	if req.SessionID == "" {
+		c.JSON(errorResponse(c, invalidRequest("Не указан sessionId")))
+		return
+	}
+
+	language, err := resolveLanguage(req.Language)
+	if err != nil {
+		c.JSON(errorResponse(c, languageError(err)))
+		return
+	}
+
+	log.Printf("💬 Получен запрос на анализ сессии: %s", req.SessionID)
+
+	resp, err := runSessionAnalysis(c.Request.Context(), req.SessionID, language)
+	if err != nil {
+		c.JSON(errorResponse(c, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// SessionAnalysisResponse - тело успешного ответа анализа сессии
+type SessionAnalysisResponse struct {
+	*AnalysisResponse
+	Session *SessionInfo `json:"session"`
+}
+
+// SessionInfo - сведения о том, какие трейсы сессии были проанализированы
+type SessionInfo struct {
+	SessionID      string   `json:"sessionId"`
+	TotalTraces    int      `json:"totalTraces"`
+	AnalyzedTraces []string `json:"analyzedTraces"` // ID трейсов в порядке ходов
+	Truncated      bool     `json:"truncated"`
+}
+
+// runSessionAnalysis загружает сессию и полные данные ее трейсов
+// и анализирует их одним запросом к AI
+func runSessionAnalysis(ctx context.Context, sessionID, language string) (*SessionAnalysisResponse, error) {
+	started := time.Now()
+	defer metrics.TrackAnalysis("session")()
+	ctx = ai.WithAnswered(ctx)
+	log.Println("🔄 ШАГ 1: Получение сессии из Langfuse")
+
+	session, err := langfuseClient.GetSession(ctx, sessionID)
+	if err != nil {
+		log.Printf("❌ Ошибка получения сессии: %v", err)
+		return nil, &fetchError{resource: "session", err: err}
+	}
+
+	sort.SliceStable(session.Traces, func(i, j int) bool {
+		return session.Traces[i].Timestamp.Before(session.Traces[j].Timestamp)
+	})
+
+	info := &SessionInfo{
+		SessionID:   session.ID,
+		TotalTraces: len(session.Traces),
+	}
+	// Для длинных сессий оставляем последние ходы: проблемы между ходами
+	// обычно накапливаются к концу диалога
+	if len(session.Traces) > sessionMaxTraces {
+		session.Traces = session.Traces[len(session.Traces)-sessionMaxTraces:]
+		info.Truncated = true
+	}
+
+	log.Printf("✅ Сессия получена, трейсов: %d (анализируем %d)", info.TotalTraces, len(session.Traces))
+	log.Println("🔄 ШАГ 2: Получение полных данных трейсов")
+
+	if err := loadSessionTraces(ctx, session); err != nil {
+		log.Printf("❌ Ошибка получения трейса сессии: %v", err)
+		return nil, &fetchError{resource: "trace", err: err}
+	}
+
+	// Бюджет токенов делится между трейсами сессии поровну
+	budget := compactBudget
+	if len(session.Traces) > 0 {
+		budget.MaxTokens /= len(session.Traces)
+	}
+	report := &compact.Report{}
+
+	findings := []heuristics.Finding{}
+	for i := range session.Traces {
+		info.AnalyzedTraces = append(info.AnalyzedTraces, session.Traces[i].ID)
+		findings = append(findings, runHeuristics(&session.Traces[i], language)...)
+
+		compacted, traceReport := compactTrace(&session.Traces[i], budget)
+		session.Traces[i] = *compacted
+		report.Merge(traceReport)
+	}
+
+	log.Println("🤖 ШАГ 3: Отправка сессии на анализ AI")
+
+	result, err := analyzer.Analyze(ctx, &ai.AnalysisRequest{
+		Session:  session,
+		Facts:    findings,
+		Language: language,
+	})
+	if err != nil {
+		log.Printf("❌ Ошибка анализа AI: %v", err)
+		return nil, err
+	}
+
+	log.Printf("✅ AI анализ сессии завершён: %s / %s", result.AnalysisSummary.OverallStatus, result.DetailedAnalysis.AnomalyType)
+	return &SessionAnalysisResponse{
+		AnalysisResponse: &AnalysisResponse{
+			Data:       result,
+			Heuristics: findings,
+			Compaction: report,
+			Metadata:   newMetadata(ctx, started, language),
+		},
+		Session: info,
+	}, nil
+}
+
+// loadSessionTraces заменяет краткие трейсы сессии полными (с наблюдениями)
+func loadSessionTraces(ctx context.Context, session *langfuse.Session) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sem := make(chan struct{}, sessionFetchWorkers)
+	errs := make(chan error, len(session.Traces))
+	var wg sync.WaitGroup
+	for i := range session.Traces {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			trace, err := langfuseClient.GetTrace(ctx, session.Traces[i].ID)
+			if err != nil {
+				errs <- err
+				cancel()
+				return
+			}
+			session.Traces[i] = *trace
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	return <-errs
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"langfuse-analyzer-backend/ai"
+	"langfuse-analyzer-backend/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Этапы анализа, которые отправляются клиенту в событиях "stage"
+const (
+	stageFetchingTrace  = "fetching_trace"
+	stageTraceFetched   = "trace_fetched"
+	stageHeuristics     = "heuristics"
+	stageSendingToModel = "sending_to_model"
+	stageRepairing      = "repairing"
+	stageChunkAnalyzed  = "chunk_analyzed"
+)
+
+// handleAnalyzeStream выполняет анализ трейса и передает прогресс через
+// Server-Sent Events. Поддерживает GET /analyze/stream?traceId=... и
+// POST /analyze/stream с тем же телом, что и /analyze.
+//
+// События:
+//   - stage:  {"stage": "..."} — переход к следующему этапу
+//   - token:  {"text": "..."} — очередной фрагмент ответа модели. Если ответ
+//     не прошел проверку, приходит stage "repairing" со списком проблем,
+//     и токены исправленного ответа идут заново. Большой трейс, разбитый
+//     на части, анализируется без токенов: вместо них после каждой части
+//     приходит stage "chunk_analyzed" с полями done и total.
+//   - result: {"data": ..., "heuristics": [...]} — итоговый результат (как в /analyze)
+//   - error:  {"status": 429, "code": "...", "message": "...", ...} — ошибка
+//     в том же формате, что и ответы с ошибкой, плюс HTTP статус; поток завершается
+func handleAnalyzeStream(c *gin.Context) {
+	var req AnalyzeRequest
+	if c.Request.Method == http.MethodGet {
+		req.TraceID = c.Query("traceId")
+		req.Language = c.Query("language")
+	} else if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("❌ Ошибка парсинга JSON: %v", err)
+		c.JSON(errorResponse(c, invalidRequest("Некорректный JSON: "+err.Error())))
+		return
+	}
+	if req.TraceID == "" {
+		c.JSON(errorResponse(c, invalidRequest("Не указан traceId")))
+		return
+	}
+	language, err := resolveLanguage(req.Language)
+	if err != nil {
+		c.JSON(errorResponse(c, languageError(err)))
+		return
+	}
+
+	log.Printf("📡 Потоковый анализ traceId: %s", req.TraceID)
+
+	// Поток длится столько, сколько генерирует модель: общий таймаут
+	// записи ответа сервера (server.writeTimeout) к нему не применяется
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("⚠️  Не удалось снять таймаут записи для потока: %v", err)
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	send := func(event string, data interface{}) {
+		c.SSEvent(event, data)
+		c.Writer.Flush()
+	}
+	sendError := func(err error) {
+		status, body := errorResponse(c, err)
+		body.Status = status
+		send("error", body)
+	}
+
+	started := time.Now()
+	defer metrics.TrackAnalysis("stream")()
+	ctx := ai.WithAnswered(c.Request.Context())
+
+	send("stage", gin.H{"stage": stageFetchingTrace})
+	trace, err := langfuseClient.GetTrace(ctx, req.TraceID)
+	if err != nil {
+		log.Printf("❌ Ошибка получения трейса: %v", err)
+		sendError(err)
+		return
+	}
+	send("stage", gin.H{
+		"stage":        stageTraceFetched,
+		"observations": len(trace.Observations),
+	})
+
+	findings := runHeuristics(trace, language)
+	send("stage", gin.H{
+		"stage":      stageHeuristics,
+		"heuristics": findings,
+	})
+
+	plan := planTrace(trace)
+
+	var result *ai.AnalysisResult
+	if plan.chunked() {
+		send("stage", gin.H{"stage": stageSendingToModel, "chunks": len(plan.parts)})
+		// Ответы частей не транслируются: клиент получает только прогресс
+		result, err = analyzer.AnalyzeChunks(ctx, &ai.AnalysisRequest{Trace: trace, Facts: findings, Language: language},
+			plan.parts, chunkConcurrency,
+			func(done, total int) {
+				send("stage", gin.H{
+					"stage": stageChunkAnalyzed,
+					"done":  done,
+					"total": total,
+				})
+			},
+		)
+	} else {
+		send("stage", gin.H{"stage": stageSendingToModel})
+		result, err = analyzer.AnalyzeStream(ctx, &ai.AnalysisRequest{Trace: plan.trace, Facts: findings, Language: language},
+			func(token string) {
+				send("token", gin.H{"text": token})
+			},
+			func(problems []string) {
+				send("stage", gin.H{
+					"stage":    stageRepairing,
+					"problems": problems,
+				})
+			},
+		)
+	}
+	if err != nil {
+		log.Printf("❌ Ошибка анализа AI: %v", err)
+		sendError(err)
+		return
+	}
+
+	send("result", plan.response(ctx, result, findings, started, language))
+
+	log.Printf("✅ Потоковый анализ traceId %s завершён", req.TraceID)
+}